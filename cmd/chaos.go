@@ -0,0 +1,49 @@
+/*
+Copyright © 2022 codeperfio <filipecosta.90@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/codeperfio/pubsub-bench/cmd/subscribe"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringArray("chaos", []string{}, fmt.Sprintf("chaos action executed against the redis nodes during the run, as \"<offset> <action> [shard=<index>] [node=<addr>] [role=primary|replica] [duration=<duration>]\" ( e.g. \"30s failover shard=2\" ). The offset starts with the first message. Can be repeated, or listed under the chaos key of the config file. (actions %s)", strings.Join(subscribe.ChaosActionChoices, ",")))
+	rootCmd.PersistentFlags().Float64("chaos-recovery-threshold", 0.9, "fraction of the pre-event message rate the rate must reach again for a chaos action to be considered recovered.")
+	// allow the chaos timeline to be kept in the config file
+	for _, name := range []string{"chaos", "chaos-recovery-threshold"} {
+		if err := viper.BindPFlag(name, rootCmd.PersistentFlags().Lookup(name)); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// chaosActions parses the chaos timeline from the flags or the config file.
+func chaosActions() []subscribe.ChaosAction {
+	actions, err := subscribe.ParseChaosActions(viper.GetStringSlice("chaos"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return actions
+}
+
+func chaosRecoveryThreshold() float64 {
+	return viper.GetFloat64("chaos-recovery-threshold")
+}
@@ -0,0 +1,78 @@
+/*
+Copyright © 2022 codeperfio <filipecosta.90@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/codeperfio/pubsub-bench/cmd/subscribe"
+	"github.com/spf13/cobra"
+)
+
+// clusterCmd represents the cluster command
+var clusterCmd = &cobra.Command{
+	Use:   "cluster",
+	Short: "Start a local redis cluster",
+	Long: `Start --nodes redis-server primaries ( and --cluster-replicas replicas per primary ) from --cluster-server-bin,
+create the cluster, wait for cluster_state:ok and print the endpoints. The nodes are stopped on Ctrl-c.`,
+	Run: clusterLogic,
+}
+
+func init() {
+	rootCmd.AddCommand(clusterCmd)
+	clusterCmd.Flags().Int("nodes", 3, "number of primaries.")
+	rootCmd.PersistentFlags().Int("local-cluster", 0, "if set, start a local redis cluster with this number of primaries for the redis systems, use it instead of --host and --port, and stop it at the end of the run.")
+	rootCmd.PersistentFlags().String("cluster-server-bin", "redis-server", "redis-server binary used by the cluster command and --local-cluster.")
+	rootCmd.PersistentFlags().Int("cluster-replicas", 0, "number of replicas per primary of the local cluster.")
+	rootCmd.PersistentFlags().Int("cluster-base-port", 30001, "port of the first local cluster node, the others use the following ports.")
+	rootCmd.PersistentFlags().Int("cluster-cpu-start", -1, "if not negative, pin each local cluster node to its own core, starting with this one (linux only).")
+	rootCmd.PersistentFlags().String("cluster-dir", "", "directory of the local cluster configs, data and logs. If not set, a temporary directory is used and removed afterwards.")
+	rootCmd.PersistentFlags().String("cluster-server-args", "", "additional redis-server arguments of the local cluster nodes ( e.g. \"--io-threads 2\" ).")
+}
+
+// startLocalCluster starts the local cluster described by the --cluster-* options.
+func startLocalCluster(cmd *cobra.Command, primaries int) *subscribe.LocalCluster {
+	options := subscribe.LocalClusterOptions{Host: "127.0.0.1", Primaries: primaries}
+	options.ServerBinary, _ = cmd.Flags().GetString("cluster-server-bin")
+	options.ReplicasPerPrimary, _ = cmd.Flags().GetInt("cluster-replicas")
+	options.BasePort, _ = cmd.Flags().GetInt("cluster-base-port")
+	options.CPUStart, _ = cmd.Flags().GetInt("cluster-cpu-start")
+	options.Dir, _ = cmd.Flags().GetString("cluster-dir")
+	serverArgs, _ := cmd.Flags().GetString("cluster-server-args")
+	options.ServerArgs = strings.Fields(serverArgs)
+	cluster, err := subscribe.StartLocalCluster(options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cluster
+}
+
+func clusterLogic(cmd *cobra.Command, args []string) {
+	nodes, _ := cmd.Flags().GetInt("nodes")
+	cluster := startLocalCluster(cmd, nodes)
+	fmt.Printf("Local cluster endpoints: %s\n", strings.Join(cluster.Addresses(), ","))
+
+	// listen for C-c
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	<-c
+	fmt.Println("received Ctrl-c - shutting down")
+	cluster.Stop()
+}
@@ -0,0 +1,191 @@
+/*
+Copyright © 2022 codeperfio <filipecosta.90@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// findMaxCmd represents the find-max command
+var findMaxCmd = &cobra.Command{
+	Use:   "find-max",
+	Short: "Search the maximum sustainable publish rate",
+	Long: `Run the subscribe workload described by the flags at increasing --publish-rate, doubling it from --min-rate
+until a probe fails and then bisecting, to find the highest rate where the delivered message rate stays within
+--tolerance percent of the offered one and the p99 latency stays under --p99-slo. Every probe and the final rate are reported.`,
+	Run: findMaxLogic,
+}
+
+func init() {
+	rootCmd.AddCommand(findMaxCmd)
+	findMaxCmd.Flags().Int("min-rate", 1, "publish rate, per channel, of the first probe.")
+	findMaxCmd.Flags().Int("max-rate", 100000, "highest publish rate, per channel, probed.")
+	findMaxCmd.Flags().Float64("tolerance", 5, "maximum percentage by which the delivered message rate of a sustained probe can be below the offered one.")
+	findMaxCmd.Flags().Float64("p99-slo", 0, "maximum p99 latency, in milliseconds, of a sustained probe. 0 disables the latency check.")
+	findMaxCmd.Flags().Float64("resolution", 5, "stop bisecting once the gap between the sustained and the failed rates is below this percentage of the sustained one.")
+	findMaxCmd.Flags().Int("probe-time", 10, "number of seconds of each probe, after receiving the first message. A probe without messages fails once --warmup plus twice this time elapsed.")
+}
+
+// probeResult is a find-max run at a given publish rate.
+type probeResult struct {
+	// OfferedRate is the message rate the subscribers receive if every published message is delivered
+	OfferedRate float64 `json:"OfferedRate"`
+	Sustained   bool    `json:"Sustained"`
+	Reason      string  `json:"Reason,omitempty"`
+	testResult
+}
+
+type findMaxResult struct {
+	Tolerance     float64       `json:"Tolerance"`
+	LatencyP99SLO float64       `json:"LatencyP99SLO"`
+	Probes        []probeResult `json:"Probes"`
+	// MaxPublishRate is the highest sustained publish rate per channel, 0 if none was
+	MaxPublishRate int     `json:"MaxPublishRate"`
+	MaxMessageRate float64 `json:"MaxMessageRate"`
+}
+
+// offeredRate is the message rate the subscribers of a run receive if every published message is delivered: once per
+// subscriber for the broadcast systems, once per channel for the work queues. Each keyspace write is only notified to
+// the subscribers of the node holding its key, and only when notify-keyspace-events enables its event class.
+func offeredRate(system string, stream_group string, nats_queue_group string, keyspace_events string, res testResult) float64 {
+	channels := res.ChannelMax - res.ChannelMin + 1
+	switch {
+	case system == redisList || (system == redisStreams && stream_group != "") || (system == natsPubSub && nats_queue_group != ""):
+		return float64(res.PublishRate * channels)
+	case system == redisKeyspaceNotifications:
+		return float64(res.PublishRate*channels*res.SubscribersPerChannel) * keyspaceNotifiedWrites(keyspace_events)
+	}
+	return float64(res.PublishRate * res.TotalSubscriptions)
+}
+
+// keyspaceNotifiedWrites is the fraction of the SET, EXPIRE and DEL writes of the keyspace publishers that are
+// notified on the counted channels with the given notify-keyspace-events.
+func keyspaceNotifiedWrites(keyspace_events string) float64 {
+	if !strings.ContainsAny(keyspace_events, "KE") {
+		return 0
+	}
+	notified := 0
+	// SET is a string command, EXPIRE and DEL are generic ones
+	if strings.ContainsAny(keyspace_events, "$A") {
+		notified++
+	}
+	if strings.ContainsAny(keyspace_events, "gA") {
+		notified += 2
+	}
+	return float64(notified) / 3
+}
+
+func findMaxLogic(cmd *cobra.Command, args []string) {
+	json_out_file, _ := cmd.Flags().GetString("json-out-file")
+	min_rate, _ := cmd.Flags().GetInt("min-rate")
+	max_rate, _ := cmd.Flags().GetInt("max-rate")
+	tolerance, _ := cmd.Flags().GetFloat64("tolerance")
+	p99_slo, _ := cmd.Flags().GetFloat64("p99-slo")
+	resolution, _ := cmd.Flags().GetFloat64("resolution")
+	probe_time, _ := cmd.Flags().GetInt("probe-time")
+	warmup, _ := cmd.Flags().GetDuration("warmup")
+	system, _ := cmd.Flags().GetString("system")
+	stream_group, _ := cmd.Flags().GetString("stream-group")
+	nats_queue_group, _ := cmd.Flags().GetString("nats-queue-group")
+	keyspace_events, _ := cmd.Flags().GetString("keyspace-events")
+	if min_rate < 1 || max_rate < min_rate {
+		log.Fatal(fmt.Errorf("--min-rate must be at least 1 and not above --max-rate"))
+	}
+	if probe_time < 1 {
+		log.Fatal(fmt.Errorf("--probe-time must be at least 1 second"))
+	}
+	cmd.Flags().Set("test-time", strconv.Itoa(probe_time))
+	cmd.Flags().Set("messages", "0")
+
+	result := findMaxResult{Tolerance: tolerance, LatencyP99SLO: p99_slo}
+	// probe runs the workload at the given rate, returning false once interrupted
+	probe := func(rate int) (sustained bool, completed bool) {
+		cmd.Flags().Set("publish-rate", strconv.Itoa(rate))
+		log.Printf("Probing a publish rate of %d messages per second per channel", rate)
+		// a probe that receives no message, or too late, ends at its deadline instead of waiting for the first one
+		control := newRunControl()
+		control.deadline = warmup + 2*time.Duration(probe_time)*time.Second
+		res, completed := runSubscribe(cmd, control)
+		// the calibration baseline does not change between probes
+		cmd.Flags().Set("calibration-time", "0")
+		p := probeResult{OfferedRate: offeredRate(system, stream_group, nats_queue_group, keyspace_events, res), Sustained: true, testResult: res}
+		if res.TotalMessages == 0 {
+			p.Sustained, p.Reason = false, fmt.Sprintf("no message received within the probe deadline of %s", control.deadline)
+		} else if p.MessageRate < p.OfferedRate*(1-tolerance/100) {
+			p.Sustained, p.Reason = false, fmt.Sprintf("delivered %.1f%% of the offered rate", 100*p.MessageRate/p.OfferedRate)
+		} else if p99_slo > 0 && p.LatencyP99 > p99_slo {
+			p.Sustained, p.Reason = false, fmt.Sprintf("p99 latency %.3f ms above the SLO", p.LatencyP99)
+		}
+		log.Printf("Publish rate %d: offered %.2f, delivered %.2f messages per second, p99 latency %.3f ms: sustained %v %s", rate, p.OfferedRate, p.MessageRate, p.LatencyP99, p.Sustained, p.Reason)
+		result.Probes = append(result.Probes, p)
+		if p.Sustained && rate > result.MaxPublishRate {
+			result.MaxPublishRate, result.MaxMessageRate = rate, p.MessageRate
+		}
+		return p.Sustained, completed
+	}
+
+	// double the rate until a probe fails, then bisect between the last sustained and the failed rates
+	sustained_rate, failed_rate := 0, 0
+	for rate := min_rate; failed_rate == 0; rate *= 2 {
+		if rate > max_rate {
+			rate = max_rate
+		}
+		sustained, completed := probe(rate)
+		if !completed {
+			break
+		}
+		if !sustained {
+			failed_rate = rate
+		} else if sustained_rate = rate; rate == max_rate {
+			break
+		}
+	}
+	for sustained_rate > 0 && failed_rate > 0 && float64(failed_rate-sustained_rate) > float64(sustained_rate)*resolution/100 && failed_rate-sustained_rate > 1 {
+		rate := (sustained_rate + failed_rate) / 2
+		sustained, completed := probe(rate)
+		if !completed {
+			break
+		}
+		if sustained {
+			sustained_rate = rate
+		} else {
+			failed_rate = rate
+		}
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 25, 0, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, "Publish Rate\tOffered Rate\tMessage Rate\tLatency p99 (ms)\tSustained\t\n")
+	for _, p := range result.Probes {
+		fmt.Fprint(w, fmt.Sprintf("%d\t%.2f\t%.2f\t%.3f\t%v\t%s\n", p.PublishRate, p.OfferedRate, p.MessageRate, p.LatencyP99, p.Sustained, p.Reason))
+	}
+	w.Flush()
+	if result.MaxPublishRate > 0 {
+		fmt.Printf("Maximum sustainable publish rate %d messages per second per channel, delivering %.2f messages per second\n", result.MaxPublishRate, result.MaxMessageRate)
+	} else {
+		fmt.Printf("No sustainable publish rate found, starting from --min-rate %d\n", min_rate)
+	}
+
+	writeJSON(json_out_file, result)
+}
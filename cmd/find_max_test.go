@@ -0,0 +1,77 @@
+/*
+Copyright © 2022 codeperfio <filipecosta.90@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/codeperfio/pubsub-bench/cmd/subscribe"
+)
+
+func TestOfferedRate(t *testing.T) {
+	res := testResult{PublishRate: 10, ChannelMin: 1, ChannelMax: 100, SubscribersPerChannel: 2, TotalSubscriptions: 200}
+	tests := []struct {
+		name             string
+		system           string
+		stream_group     string
+		nats_queue_group string
+		keyspace_events  string
+		res              testResult
+		want             float64
+	}{
+		{"pubsub", redisPubSub, "", "", "", res, 2000},
+		{"sharded pubsub", redisShardedPubSub, "", "", "", res, 2000},
+		{"list", redisList, "", "", "", res, 1000},
+		{"streams group", redisStreams, "benchmark", "", "", res, 1000},
+		{"streams readers", redisStreams, "", "", "", res, 2000},
+		{"nats queue group", natsPubSub, "", "workers", "", res, 1000},
+		{"nats", natsPubSub, "", "", "", res, 2000},
+		// 3 nodes, 2 subscribers each
+		{"keyspace default events", redisKeyspaceNotifications, "", "", "KEg$", testResult{PublishRate: 10, ChannelMin: 1, ChannelMax: 100, SubscribersPerChannel: 2, TotalSubscriptions: 6}, 2000},
+		{"keyspace all events", redisKeyspaceNotifications, "", "", "EA", res, 2000},
+		{"keyspace string events", redisKeyspaceNotifications, "", "", "K$", res, 2000.0 / 3},
+		{"keyspace generic events", redisKeyspaceNotifications, "", "", "Eg", res, 4000.0 / 3},
+		{"keyspace no channel", redisKeyspaceNotifications, "", "", "g$", res, 0},
+		{"keyspace no class", redisKeyspaceNotifications, "", "", "KEl", res, 0},
+		{"no publishers", redisPubSub, "", "", "", testResult{ChannelMin: 1, ChannelMax: 100, TotalSubscriptions: 200}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := offeredRate(tt.system, tt.stream_group, tt.nats_queue_group, tt.keyspace_events, tt.res); got != tt.want {
+				t.Errorf("offeredRate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateCLIDeadline(t *testing.T) {
+	subscribe.ResetMetrics()
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	control := newRunControl()
+	control.deadline = 300 * time.Millisecond
+	begin := time.Now()
+	completed, _, _, totalMessages, _, _, _ := updateCLI(tick, make(chan os.Signal), 0, new(tabwriter.Writer), 10, 0, control)
+	if took := time.Since(begin); took < control.deadline || took > 2*time.Second {
+		t.Errorf("run without messages ended after %s, want the deadline of %s", took, control.deadline)
+	}
+	if !completed || totalMessages != 0 {
+		t.Errorf("updateCLI() = %v, %d messages, want a completed run without messages", completed, totalMessages)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright © 2022 codeperfio <filipecosta.90@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/codeperfio/pubsub-bench/cmd/subscribe"
+	"github.com/spf13/cobra"
+)
+
+// proxyCmd represents the proxy command
+var proxyCmd = &cobra.Command{
+	Use:   "proxy",
+	Short: "Run the TCP fault-injection proxy",
+	Long: `Forward each --route listen address to its redis node, injecting latency, jitter, bandwidth caps,
+stalls and connection resets, so that lossy or cross-AZ networks can be reproduced without tc/netem privileges.
+Use --address-map-file to point the benchmark at the proxies of every cluster node, or --fault-proxy to run them in-process.`,
+	Run: proxyLogic,
+}
+
+func init() {
+	rootCmd.AddCommand(proxyCmd)
+	proxyCmd.Flags().StringArray("route", []string{}, "\"<listen host:port>=<redis host:port>\" route. Can be repeated, one per redis node.")
+	rootCmd.PersistentFlags().Bool("fault-proxy", false, "connect the redis subscribers and built-in publishers through an in-process fault-injection proxy per node, configured by the --proxy-* options.")
+	rootCmd.PersistentFlags().Duration("proxy-latency", 0, "latency added by the fault proxy to each direction of every connection.")
+	rootCmd.PersistentFlags().Duration("proxy-jitter", 0, "maximum random delay added by the fault proxy on top of --proxy-latency. The data of a connection is never reordered.")
+	rootCmd.PersistentFlags().Int64("proxy-bandwidth", 0, "maximum throughput, in bytes per second, of each direction of every proxied connection. 0 for unlimited.")
+	rootCmd.PersistentFlags().Float64("proxy-stall-rate", 0, "probability of each forwarded chunk to be held during --proxy-stall, as a dropped packet waiting for its retransmission.")
+	rootCmd.PersistentFlags().Duration("proxy-stall", 200*time.Millisecond, "duration of the fault proxy stalls.")
+	rootCmd.PersistentFlags().Float64("proxy-reset-rate", 0, "probability of each forwarded chunk to reset the proxied connection instead.")
+}
+
+// faultProfile reads the --proxy-* options.
+func faultProfile(cmd *cobra.Command) subscribe.FaultProfile {
+	profile := subscribe.FaultProfile{}
+	profile.Latency, _ = cmd.Flags().GetDuration("proxy-latency")
+	profile.Jitter, _ = cmd.Flags().GetDuration("proxy-jitter")
+	profile.Bandwidth, _ = cmd.Flags().GetInt64("proxy-bandwidth")
+	profile.StallRate, _ = cmd.Flags().GetFloat64("proxy-stall-rate")
+	profile.Stall, _ = cmd.Flags().GetDuration("proxy-stall")
+	profile.ResetRate, _ = cmd.Flags().GetFloat64("proxy-reset-rate")
+	if profile.Latency < 0 || profile.Jitter < 0 || profile.Bandwidth < 0 || profile.Stall < 0 {
+		log.Fatal(fmt.Errorf("--proxy-latency, --proxy-jitter, --proxy-bandwidth and --proxy-stall can not be negative"))
+	}
+	if profile.StallRate < 0 || profile.StallRate > 1 || profile.ResetRate < 0 || profile.ResetRate > 1 {
+		log.Fatal(fmt.Errorf("--proxy-stall-rate and --proxy-reset-rate must be between 0 and 1"))
+	}
+	return profile
+}
+
+func proxyLogic(cmd *cobra.Command, args []string) {
+	routes, _ := cmd.Flags().GetStringArray("route")
+	if len(routes) == 0 {
+		log.Fatal(fmt.Errorf("at least one --route is required"))
+	}
+	profile := faultProfile(cmd)
+	proxies := []*subscribe.FaultProxy{}
+	for _, route := range routes {
+		parts := strings.SplitN(route, "=", 2)
+		if len(parts) != 2 {
+			log.Fatal(fmt.Errorf("invalid --route %q, expected \"<listen host:port>=<redis host:port>\"", route))
+		}
+		proxy, err := subscribe.StartFaultProxy(parts[0], parts[1], profile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Fault proxy %s forwarding to %s", proxy.Listen, proxy.Target)
+		proxies = append(proxies, proxy)
+	}
+
+	// listen for C-c
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	<-c
+	fmt.Println("received Ctrl-c - shutting down")
+	for _, proxy := range proxies {
+		proxy.Close()
+	}
+	if stats := subscribe.DeliveryStats(); stats != nil {
+		log.Printf("Fault proxy stalls %d, resets %d", stats["proxy-stalls"], stats["proxy-resets"])
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright © 2022 codeperfio <filipecosta.90@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/codeperfio/pubsub-bench/cmd/subscribe"
+	"github.com/spf13/viper"
+)
+
+var redisConnectionFlags = []string{"user", "password", "tls", "tls-ca-cert", "tls-cert", "tls-key", "tls-server-name", "tls-skip-verify", "address-map-file", "address-map-rule", "sentinel", "sentinel-master"}
+
+func init() {
+	rootCmd.PersistentFlags().String("user", "", "ACL username used to AUTH every redis connection. Requires --password.")
+	rootCmd.PersistentFlags().String("password", "", "password used to AUTH every redis connection.")
+	rootCmd.PersistentFlags().Bool("tls", false, "connect to redis using TLS.")
+	rootCmd.PersistentFlags().String("tls-ca-cert", "", "CA certificate file used to verify the redis server certificates. If not set, the system CAs are used.")
+	rootCmd.PersistentFlags().String("tls-cert", "", "client certificate file, for redis deployments requiring client authentication.")
+	rootCmd.PersistentFlags().String("tls-key", "", "client private key file, for redis deployments requiring client authentication.")
+	rootCmd.PersistentFlags().String("tls-server-name", "", "server name (SNI) used to verify the redis server certificates. If not set, the host of each node address is used.")
+	rootCmd.PersistentFlags().Bool("tls-skip-verify", false, "skip the verification of the redis server certificates.")
+	rootCmd.PersistentFlags().String("address-map-file", "", "file of \"<announced> <reachable>\" lines, remapping the node addresses announced by the cluster (host:port, or host alone to keep the announced port). Useful for clusters behind Docker or cloud NAT.")
+	rootCmd.PersistentFlags().String("address-map-rule", "", fmt.Sprintf("rule remapping the announced node addresses missing from --address-map-file. seed-host replaces the announced host with the first --host one, keeping the announced port. (choices %s)", strings.Join(subscribe.AddressMapRuleChoices, ",")))
+	rootCmd.PersistentFlags().String("sentinel", "", "comma separated Redis Sentinel endpoints (host, host:port or redis:// URI, default port 26379). If set, the primary of --sentinel-master and its replicas are resolved through Sentinel instead of --host, and the failovers are followed during the run.")
+	rootCmd.PersistentFlags().String("sentinel-master", "mymaster", "name of the master set monitored by the --sentinel endpoints.")
+	// allow the connection settings to be kept in the config file
+	for _, name := range redisConnectionFlags {
+		if err := viper.BindPFlag(name, rootCmd.PersistentFlags().Lookup(name)); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// applyRedisConnectionOptions reads the credentials and TLS settings from the flags or the config file.
+func applyRedisConnectionOptions() {
+	options := subscribe.RedisConnectionOptions{
+		Username:           viper.GetString("user"),
+		Password:           viper.GetString("password"),
+		TLS:                viper.GetBool("tls"),
+		CACert:             viper.GetString("tls-ca-cert"),
+		Cert:               viper.GetString("tls-cert"),
+		Key:                viper.GetString("tls-key"),
+		ServerName:         viper.GetString("tls-server-name"),
+		InsecureSkipVerify: viper.GetBool("tls-skip-verify"),
+	}
+	if options.Username != "" && options.Password == "" {
+		log.Fatal("--user requires --password")
+	}
+	if err := subscribe.SetRedisConnectionOptions(options); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// applyRedisEndpoints validates the --host and --port endpoints and the address map before any connection is opened.
+func applyRedisEndpoints(host string, port string, debugLevel int) []subscribe.Endpoint {
+	endpoints, err := subscribe.SetEndpoints(host, port)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = subscribe.SetAddressMap(viper.GetString("address-map-file"), viper.GetString("address-map-rule"), debugLevel); err != nil {
+		log.Fatal(err)
+	}
+	return endpoints
+}
+
+// applySentinel resolves the primary of the --sentinel-master set, returning it as the seed host. It returns host
+// unchanged when --sentinel is not set. It must be called after applyRedisEndpoints, so that the announced primary is remapped.
+func applySentinel(host string) (string, bool) {
+	sentinel := viper.GetString("sentinel")
+	if sentinel == "" {
+		return host, false
+	}
+	primary, err := subscribe.SetSentinel(sentinel, viper.GetString("sentinel-master"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Sentinel resolved the primary of %s to %s", viper.GetString("sentinel-master"), primary)
+	return primary, true
+}
+
+// sentinelConfigured tells if the primary is resolved through Sentinel.
+func sentinelConfigured() bool {
+	return viper.GetString("sentinel") != ""
+}
+
+// addressMapEnabled tells if the announced node addresses are remapped.
+func addressMapEnabled() bool {
+	return viper.GetString("address-map-file") != "" || viper.GetString("address-map-rule") != ""
+}
+
+// hasUnixEndpoint tells if any endpoint is a unix socket, which only the resp and go-redis clients can use.
+func hasUnixEndpoint(endpoints []subscribe.Endpoint) bool {
+	for _, endpoint := range endpoints {
+		if endpoint.Network == "unix" {
+			return true
+		}
+	}
+	return false
+}
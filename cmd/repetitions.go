@@ -0,0 +1,195 @@
+/*
+Copyright © 2022 codeperfio <filipecosta.90@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// repetitionMetrics are the testResult metrics summarized over the repetitions.
+var repetitionMetrics = []string{"MessageRate", "Duration", "TotalMessages", "LatencyMean", "LatencyP50", "LatencyP99", "LatencyMax", "SubscribeLatencyP50", "SubscribeLatencyP99", "ConsumerIdleTime"}
+
+// tCritical95 is the two-sided 95% critical value of the Student t distribution for 1 to 30 degrees of freedom.
+var tCritical95 = []float64{12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228, 2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086, 2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042}
+
+// outlierThreshold is the modified z-score ( based on the median absolute deviation ) above which a run is an outlier.
+const outlierThreshold = 3.5
+
+func init() {
+	subscribeCmd.Flags().Int("repetitions", 1, "number of runs of the workload. Above 1, the median, mean, standard deviation, min, max and 95% confidence interval of each metric are reported, and the outlier runs are flagged.")
+	rootCmd.PersistentFlags().Bool("reset-server", false, "before each run, FLUSHALL the primaries and CONFIG RESETSTAT and MEMORY PURGE every node of the redis systems, so that every run starts from the same server state.")
+}
+
+// metricSummary describes the values a metric took over the repetitions.
+type metricSummary struct {
+	Median   float64 `json:"Median"`
+	Mean     float64 `json:"Mean"`
+	StdDev   float64 `json:"StdDev"`
+	Min      float64 `json:"Min"`
+	Max      float64 `json:"Max"`
+	CI95Low  float64 `json:"CI95Low"`
+	CI95High float64 `json:"CI95High"`
+}
+
+// repetitionRun is one of the repetitions, with the metrics it is an outlier of.
+type repetitionRun struct {
+	Repetition int      `json:"Repetition"`
+	Outliers   []string `json:"Outliers,omitempty"`
+	testResult
+}
+
+type repetitionsResult struct {
+	Repetitions int                      `json:"Repetitions"`
+	Runs        []repetitionRun          `json:"Runs"`
+	Summary     map[string]metricSummary `json:"Summary"`
+}
+
+func repetitionMetric(res testResult, name string) float64 {
+	switch name {
+	case "MessageRate":
+		return res.MessageRate
+	case "Duration":
+		return res.Duration
+	case "TotalMessages":
+		return float64(res.TotalMessages)
+	case "LatencyMean":
+		return res.LatencyMean
+	case "LatencyP50":
+		return res.LatencyP50
+	case "LatencyP99":
+		return res.LatencyP99
+	case "LatencyMax":
+		return res.LatencyMax
+	case "SubscribeLatencyP50":
+		return res.SubscribeLatencyP50
+	case "SubscribeLatencyP99":
+		return res.SubscribeLatencyP99
+	}
+	return res.ConsumerIdleTime
+}
+
+func median(sorted []float64) float64 {
+	if len(sorted)%2 == 1 {
+		return sorted[len(sorted)/2]
+	}
+	return (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+}
+
+// summarize computes the summary of a metric, the confidence interval of its mean using the Student t distribution.
+func summarize(values []float64) (summary metricSummary) {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	summary.Median, summary.Min, summary.Max = median(sorted), sorted[0], sorted[len(sorted)-1]
+	for _, value := range values {
+		summary.Mean += value / float64(len(values))
+	}
+	summary.CI95Low, summary.CI95High = summary.Mean, summary.Mean
+	if len(values) < 2 {
+		return
+	}
+	for _, value := range values {
+		summary.StdDev += (value - summary.Mean) * (value - summary.Mean) / float64(len(values)-1)
+	}
+	summary.StdDev = math.Sqrt(summary.StdDev)
+	// above 30 degrees of freedom the normal distribution is close enough
+	t := 1.96
+	if len(values)-1 <= len(tCritical95) {
+		t = tCritical95[len(values)-2]
+	}
+	margin := t * summary.StdDev / math.Sqrt(float64(len(values)))
+	summary.CI95Low, summary.CI95High = summary.Mean-margin, summary.Mean+margin
+	return
+}
+
+// outliers returns the positions of the values whose modified z-score is above outlierThreshold.
+func outliers(values []float64) (positions []int) {
+	if len(values) < 3 {
+		return nil
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	center := median(sorted)
+	deviations := []float64{}
+	for _, value := range values {
+		deviations = append(deviations, math.Abs(value-center))
+	}
+	sort.Float64s(deviations)
+	mad := median(deviations)
+	if mad == 0 {
+		return nil
+	}
+	for pos, value := range values {
+		if 0.6745*math.Abs(value-center)/mad > outlierThreshold {
+			positions = append(positions, pos)
+		}
+	}
+	return
+}
+
+// runRepetitions runs the workload described by the flags the given number of times, and reports the summary of each metric.
+func runRepetitions(cmd *cobra.Command, repetitions int, json_out_file string) {
+	result := repetitionsResult{Repetitions: repetitions, Summary: map[string]metricSummary{}}
+	for repetition := 1; repetition <= repetitions; repetition++ {
+		log.Printf("Repetition %d/%d", repetition, repetitions)
+		res, completed := runSubscribe(cmd, nil)
+		if !completed {
+			log.Printf("Repetitions interrupted during run %d/%d, which is left out of the summary", repetition, repetitions)
+			break
+		}
+		result.Runs = append(result.Runs, repetitionRun{Repetition: repetition, testResult: res})
+		// the calibration baseline does not change between runs
+		cmd.Flags().Set("calibration-time", "0")
+	}
+	if len(result.Runs) == 0 {
+		return
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 25, 0, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, fmt.Sprintf("Metric ( %d runs )\tMedian\tMean\tStdDev\tMin\tMax\t95%% CI\t\n", len(result.Runs)))
+	for _, name := range repetitionMetrics {
+		values := []float64{}
+		for _, run := range result.Runs {
+			values = append(values, repetitionMetric(run.testResult, name))
+		}
+		summary := summarize(values)
+		result.Summary[name] = summary
+		for _, pos := range outliers(values) {
+			result.Runs[pos].Outliers = append(result.Runs[pos].Outliers, name)
+		}
+		// skip the metrics the system does not report
+		if summary.Max == 0 {
+			continue
+		}
+		fmt.Fprint(w, fmt.Sprintf("%s\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f-%.3f\t\n", name, summary.Median, summary.Mean, summary.StdDev, summary.Min, summary.Max, summary.CI95Low, summary.CI95High))
+	}
+	w.Flush()
+	for _, run := range result.Runs {
+		if len(run.Outliers) > 0 {
+			fmt.Printf("Repetition %d is an outlier of %s\n", run.Repetition, strings.Join(run.Outliers, ","))
+		}
+	}
+
+	writeJSON(json_out_file, result)
+}
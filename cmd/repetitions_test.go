@@ -0,0 +1,86 @@
+/*
+Copyright © 2022 codeperfio <filipecosta.90@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   metricSummary
+	}{
+		{"single run", []float64{5}, metricSummary{Median: 5, Mean: 5, Min: 5, Max: 5, CI95Low: 5, CI95High: 5}},
+		{"identical runs", []float64{7, 7, 7}, metricSummary{Median: 7, Mean: 7, Min: 7, Max: 7, CI95Low: 7, CI95High: 7}},
+		{"odd unsorted", []float64{3, 1, 2}, metricSummary{Median: 2, Mean: 2, StdDev: 1, Min: 1, Max: 3, CI95Low: 2 - 4.303/math.Sqrt(3), CI95High: 2 + 4.303/math.Sqrt(3)}},
+		{"even", []float64{4, 1, 3, 2}, metricSummary{Median: 2.5, Mean: 2.5, StdDev: math.Sqrt(5.0 / 3), Min: 1, Max: 4, CI95Low: 2.5 - 3.182*math.Sqrt(5.0/3)/2, CI95High: 2.5 + 3.182*math.Sqrt(5.0/3)/2}},
+		{"normal approximation above 30 degrees of freedom", sequence(32), metricSummary{Median: 16.5, Mean: 16.5, StdDev: math.Sqrt(88), Min: 1, Max: 32, CI95Low: 16.5 - 1.96*math.Sqrt(88)/math.Sqrt(32), CI95High: 16.5 + 1.96*math.Sqrt(88)/math.Sqrt(32)}},
+		{"last t critical value", sequence(31), metricSummary{Median: 16, Mean: 16, StdDev: math.Sqrt(31 * 32 / 12.0), Min: 1, Max: 31, CI95Low: 16 - 2.042*math.Sqrt(31*32/12.0)/math.Sqrt(31), CI95High: 16 + 2.042*math.Sqrt(31*32/12.0)/math.Sqrt(31)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := append([]float64{}, tt.values...)
+			got := summarize(values)
+			want := []float64{tt.want.Median, tt.want.Mean, tt.want.StdDev, tt.want.Min, tt.want.Max, tt.want.CI95Low, tt.want.CI95High}
+			for pos, value := range []float64{got.Median, got.Mean, got.StdDev, got.Min, got.Max, got.CI95Low, got.CI95High} {
+				if math.Abs(value-want[pos]) > 1e-9 {
+					t.Errorf("summarize() = %+v, want %+v", got, tt.want)
+					break
+				}
+			}
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Errorf("summarize() reordered its values to %v", values)
+			}
+		})
+	}
+}
+
+func TestOutliers(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   []int
+	}{
+		{"too few runs", []float64{1, 100}, nil},
+		{"identical runs", []float64{5, 5, 5, 5}, nil},
+		{"spread without outlier", []float64{1, 2, 3, 4, 5}, nil},
+		{"high outlier", []float64{10, 10.1, 9.9, 10, 50}, []int{4}},
+		{"low outlier", []float64{1, 10, 10.2, 9.8, 10, 10.1, 9.9}, []int{0}},
+		{"outliers on both sides", []float64{0, 10, 10.1, 9.9, 10, 20}, []int{0, 5}},
+		// without any spread among most runs there is no scale to judge the others by
+		{"most runs identical", []float64{5, 5, 100, 5, 5}, nil},
+		{"half the runs identical", []float64{5, 5, 5, 6, 7, 100}, []int{5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := outliers(tt.values); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("outliers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// sequence returns the values 1 to n.
+func sequence(n int) (values []float64) {
+	for value := 1; value <= n; value++ {
+		values = append(values, float64(value))
+	}
+	return
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/spf13/cobra"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/spf13/viper"
 )
@@ -81,7 +82,10 @@ func initConfig() {
 		viper.SetConfigName(".pubsub-bench")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	// read in environment variables that match, as PUBSUB_BENCH_<FLAG NAME>, so that USER and HOST are not mistaken for --user and --host
+	viper.SetEnvPrefix("pubsub_bench")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
@@ -0,0 +1,134 @@
+package subscribe
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+var ACLPatternLiteral string = "literal"
+var ACLPatternGlob string = "glob"
+var ACLPatternComplex string = "complex"
+var ACLPatternChoices []string = []string{ACLPatternLiteral, ACLPatternGlob, ACLPatternComplex}
+
+// aclUserPrefix names the temporary users, so that leftovers of an interrupted run are easy to spot.
+const aclUserPrefix = "pubsub-bench-acl-"
+
+type aclUser struct {
+	name     string
+	password string
+}
+
+// aclUsers are the temporary users the (sharded) pub/sub subscribers authenticate with. Empty when the ACL mode is disabled.
+var aclUsers []aclUser
+var aclUsersMinimumChannel int
+var aclNodes []string
+
+// subscriberCredentials returns the credentials of the subscribers of a channel: the temporary ACL user
+// owning the channel, or the node credentials when the ACL mode is disabled.
+func subscriberCredentials(channel_id int, addr string) RedisCredentials {
+	if len(aclUsers) == 0 {
+		return credentialsFor(addr)
+	}
+	user := aclUsers[(channel_id-aclUsersMinimumChannel)%len(aclUsers)]
+	return RedisCredentials{Username: user.name, Password: user.password}
+}
+
+// aclChannelPatterns builds the channel allow-list of a user. The patterns granting access to the user channels
+// are placed last, after patterns_per_user filler patterns, so that every channel check walks the whole list.
+func aclChannelPatterns(pattern_type string, user_id int, patterns_per_user int, channels []string, subscribe_prefix string) (patterns []string) {
+	for i := 0; i < patterns_per_user; i++ {
+		switch pattern_type {
+		case ACLPatternLiteral:
+			patterns = append(patterns, fmt.Sprintf("%sfiller-%d-%d", aclUserPrefix, user_id, i))
+		case ACLPatternGlob:
+			patterns = append(patterns, fmt.Sprintf("%sfiller-%d-%d-*", aclUserPrefix, user_id, i))
+		case ACLPatternComplex:
+			patterns = append(patterns, fmt.Sprintf("%s?iller-[a-f]*-%d-*[0-9]-%d", aclUserPrefix, user_id, i))
+		}
+	}
+	switch pattern_type {
+	case ACLPatternLiteral:
+		patterns = append(patterns, channels...)
+	case ACLPatternGlob:
+		patterns = append(patterns, subscribe_prefix+"*")
+	case ACLPatternComplex:
+		patterns = append(patterns, subscribe_prefix+"*[0-9]")
+	}
+	return
+}
+
+// CreateACLUsers creates the temporary ACL users on every node (ACLs are not propagated within a cluster),
+// each one allowed to use the channels its subscribers subscribe to.
+func CreateACLUsers(distributeSubscribers bool, host string, port string, users int, patterns_per_user int, pattern_type string, channel_maximum int, channel_minimum int, subscribe_prefix string) {
+	if distributeSubscribers {
+		topology, err := DiscoverTopologyFromArgs(host, port)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, node := range topology.Nodes() {
+			aclNodes = append(aclNodes, node.Addr)
+		}
+	} else {
+		var err error
+		if aclNodes, _, err = getClusterNodesFromArgs(port, host); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	channels := make([][]string, users)
+	for channel_id := channel_minimum; channel_id <= channel_maximum; channel_id++ {
+		user_id := (channel_id - channel_minimum) % users
+		channels[user_id] = append(channels[user_id], fmt.Sprintf("%s%d", subscribe_prefix, channel_id))
+	}
+	aclUsersMinimumChannel = channel_minimum
+	for user_id := 0; user_id < users; user_id++ {
+		secret := make([]byte, 16)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatal(err)
+		}
+		user := aclUser{name: fmt.Sprintf("%s%d", aclUserPrefix, user_id), password: hex.EncodeToString(secret)}
+		args := []string{"ACL", "SETUSER", user.name, "reset", "on", ">" + user.password, "~*", "+@all", "resetchannels"}
+		for _, pattern := range aclChannelPatterns(pattern_type, user_id, patterns_per_user, channels[user_id], subscribe_prefix) {
+			args = append(args, "&"+pattern)
+		}
+		for _, addr := range aclNodes {
+			conn, err := dialResp(addr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if _, err = conn.Do(args...); err != nil {
+				log.Fatal(fmt.Errorf("%s: ACL SETUSER %s failed: %v", addr, user.name, err))
+			}
+			conn.Close()
+		}
+		aclUsers = append(aclUsers, user)
+	}
+	log.Printf("Created %d ACL users with %d %s channel patterns each on %d nodes", users, patterns_per_user, pattern_type, len(aclNodes))
+}
+
+// DeleteACLUsers removes the users created by CreateACLUsers.
+func DeleteACLUsers() {
+	if len(aclUsers) == 0 {
+		return
+	}
+	args := []string{"ACL", "DELUSER"}
+	for _, user := range aclUsers {
+		args = append(args, user.name)
+	}
+	for _, addr := range aclNodes {
+		conn, err := dialResp(addr)
+		if err != nil {
+			log.Printf("Unable to delete the ACL users from %s: %v", addr, err)
+			continue
+		}
+		if _, err = conn.Do(args...); err != nil {
+			log.Printf("Unable to delete the ACL users from %s: %v", addr, err)
+		}
+		conn.Close()
+	}
+	log.Printf("Deleted %d ACL users", len(aclUsers))
+	aclUsers = nil
+	aclNodes = nil
+}
@@ -0,0 +1,155 @@
+package subscribe
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var AddressMapSeedHost string = "seed-host"
+var AddressMapRuleChoices []string = []string{AddressMapSeedHost}
+
+// NodeAddress is a node the tool connected to, and the address the cluster announced for it when it was remapped.
+type NodeAddress struct {
+	Addr          string `json:"Addr"`
+	AnnouncedAddr string `json:"AnnouncedAddr,omitempty"`
+}
+
+// addressMap translates the addresses announced by the cluster (e.g. internal Docker or VPC IPs) into reachable ones.
+// Entries are keyed by host:port, or by host alone to keep the announced port.
+var addressMap = map[string]string{}
+var addressMapSeedHost string
+var addressMapDebug int
+var knownAddresses = map[string]string{}
+var addressMapMutex sync.Mutex
+
+// SetAddressMap loads the announced to reachable address map file, made of "<announced> <mapped>" lines, and the
+// rule applied to the addresses not present in it. The seed-host rule replaces the host of every announced address
+// with the host of the first --host endpoint, keeping the announced port. The remapped nodes are logged from debug
+// level 1. It must be called after SetEndpoints.
+func SetAddressMap(file string, rule string, debug int) error {
+	addressMapMutex.Lock()
+	defer addressMapMutex.Unlock()
+	addressMap = map[string]string{}
+	addressMapSeedHost = ""
+	addressMapDebug = debug
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			fields := strings.Fields(text)
+			if len(fields) != 2 {
+				return fmt.Errorf("%s:%d: expected \"<announced> <mapped>\", got %q", file, line, text)
+			}
+			addressMap[fields[0]] = fields[1]
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	switch rule {
+	case "":
+	case AddressMapSeedHost:
+		if defaultEndpoint == nil || defaultEndpoint.Network != "tcp" {
+			return fmt.Errorf("the %s address map rule requires a tcp --host endpoint", rule)
+		}
+		addressMapSeedHost, _, _ = net.SplitHostPort(defaultEndpoint.Addr)
+	default:
+		return fmt.Errorf("unsupported address map rule %s ( choices %s )", rule, strings.Join(AddressMapRuleChoices, ","))
+	}
+	return nil
+}
+
+// addressMapActive tells if announced addresses are remapped.
+func addressMapActive() bool {
+	addressMapMutex.Lock()
+	defer addressMapMutex.Unlock()
+	return len(addressMap) > 0 || addressMapSeedHost != ""
+}
+
+func addressMapDebugLevel() int {
+	addressMapMutex.Lock()
+	defer addressMapMutex.Unlock()
+	return addressMapDebug
+}
+
+// mapAddress returns the reachable address of an announced one.
+func mapAddress(announced string) string {
+	addressMapMutex.Lock()
+	defer addressMapMutex.Unlock()
+	if mapped, found := addressMap[announced]; found {
+		return mapped
+	}
+	host, port, err := net.SplitHostPort(announced)
+	if err != nil {
+		return announced
+	}
+	if mappedHost, found := addressMap[host]; found {
+		return net.JoinHostPort(mappedHost, port)
+	}
+	if addressMapSeedHost != "" {
+		return net.JoinHostPort(addressMapSeedHost, port)
+	}
+	return announced
+}
+
+// mapTopology replaces the announced address of every node with its reachable one, and records both.
+func mapTopology(topology *ClusterTopology) {
+	for i := range topology.Shards {
+		shard := &topology.Shards[i]
+		mapNode(&shard.Primary)
+		for j := range shard.Replicas {
+			mapNode(&shard.Replicas[j])
+		}
+	}
+}
+
+func mapNode(node *ClusterNode) {
+	mapped := mapAddress(node.Addr)
+	if mapped != node.Addr {
+		node.AnnouncedAddr, node.Addr = node.Addr, mapped
+	}
+	if recordAddress(node.Addr, node.AnnouncedAddr) && node.AnnouncedAddr != "" && addressMapDebugLevel() >= 1 {
+		log.Printf("Mapping the announced address %s of node %s to %s", node.AnnouncedAddr, node.Id, node.Addr)
+	}
+}
+
+// resetAddresses forgets the nodes recorded by a previous run.
+func resetAddresses() {
+	addressMapMutex.Lock()
+	defer addressMapMutex.Unlock()
+	knownAddresses = map[string]string{}
+}
+
+// recordAddress adds a node to the Addresses, returning true the first time it is seen.
+func recordAddress(addr string, announced string) bool {
+	addressMapMutex.Lock()
+	defer addressMapMutex.Unlock()
+	_, found := knownAddresses[addr]
+	knownAddresses[addr] = announced
+	return !found
+}
+
+// Addresses returns every endpoint and discovered node, with its announced address when it was remapped.
+func Addresses() (addresses []NodeAddress) {
+	addressMapMutex.Lock()
+	defer addressMapMutex.Unlock()
+	for addr, announced := range knownAddresses {
+		addresses = append(addresses, NodeAddress{Addr: addr, AnnouncedAddr: announced})
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].Addr < addresses[j].Addr })
+	return
+}
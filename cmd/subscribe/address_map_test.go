@@ -0,0 +1,95 @@
+package subscribe
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMapAddress(t *testing.T) {
+	defer SetAddressMap("", "", 0)
+	mapFile := filepath.Join(t.TempDir(), "address-map")
+	content := "# announced mapped\n172.17.0.2:6379 127.0.0.1:30001\n\n172.17.0.3 localhost\n"
+	if err := os.WriteFile(mapFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		file      string
+		rule      string
+		announced string
+		want      string
+	}{
+		{"no map", "", "", "172.17.0.2:6379", "172.17.0.2:6379"},
+		{"host and port entry", mapFile, "", "172.17.0.2:6379", "127.0.0.1:30001"},
+		{"host entry keeps the port", mapFile, "", "172.17.0.3:7001", "localhost:7001"},
+		{"unmapped", mapFile, "", "172.17.0.4:6379", "172.17.0.4:6379"},
+		{"not an address", mapFile, "", "node-a", "node-a"},
+		{"seed host rule", "", AddressMapSeedHost, "172.17.0.4:7002", "seed.local:7002"},
+		{"map entries win over the rule", mapFile, AddressMapSeedHost, "172.17.0.2:6379", "127.0.0.1:30001"},
+		{"seed host rule with ipv6", "", AddressMapSeedHost, "[fd00::2]:7002", "seed.local:7002"},
+	}
+	if _, err := SetEndpoints("seed.local", "6379"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetEndpoints("127.0.0.1", "6379")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetAddressMap(tt.file, tt.rule, 0); err != nil {
+				t.Fatal(err)
+			}
+			if got := mapAddress(tt.announced); got != tt.want {
+				t.Errorf("mapAddress(%q) = %q, want %q", tt.announced, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetAddressMapErrors(t *testing.T) {
+	defer SetAddressMap("", "", 0)
+	invalid := filepath.Join(t.TempDir(), "address-map")
+	if err := os.WriteFile(invalid, []byte("172.17.0.2:6379\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetAddressMap(invalid, "", 0); err == nil {
+		t.Errorf("a line without the mapped address must be rejected")
+	}
+	if err := SetAddressMap(filepath.Join(t.TempDir(), "missing"), "", 0); err == nil {
+		t.Errorf("a missing file must be rejected")
+	}
+	if err := SetAddressMap("", "unknown", 0); err == nil {
+		t.Errorf("an unknown rule must be rejected")
+	}
+	if _, err := SetEndpoints("unix:///run/redis.sock", "6379"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetEndpoints("127.0.0.1", "6379")
+	if err := SetAddressMap("", AddressMapSeedHost, 0); err == nil {
+		t.Errorf("the seed-host rule must require a tcp endpoint")
+	}
+}
+
+func TestAddressesResetBetweenRuns(t *testing.T) {
+	defer SetAddressMap("", "", 0)
+	defer SetEndpoints("127.0.0.1", "6379")
+	if _, err := SetEndpoints("first", "7000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetAddressMap("", AddressMapSeedHost, 0); err != nil {
+		t.Fatal(err)
+	}
+	mapTopology(&ClusterTopology{Shards: []ClusterShard{{Primary: ClusterNode{Addr: "10.0.0.1:7001"}}}})
+	want := []NodeAddress{{Addr: "first:7000"}, {Addr: "first:7001", AnnouncedAddr: "10.0.0.1:7001"}}
+	if got := Addresses(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Addresses() = %+v, want %+v", got, want)
+	}
+	// a following run must only report its own endpoints
+	if _, err := SetEndpoints("second", "7000"); err != nil {
+		t.Fatal(err)
+	}
+	want = []NodeAddress{{Addr: "second:7000"}}
+	if got := Addresses(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Addresses() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,264 @@
+package subscribe
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ChaosFailover string = "failover"
+var ChaosClientKill string = "client-kill"
+var ChaosDebugSleep string = "debug-sleep"
+var ChaosNodeKill string = "node-kill"
+var ChaosActionChoices []string = []string{ChaosFailover, ChaosClientKill, ChaosDebugSleep, ChaosNodeKill}
+
+// chaosBaselineTicks is the number of ticks before an action averaged as its pre-event message rate.
+const chaosBaselineTicks = 5
+
+// ChaosAction is an entry of the chaos timeline, written as "<offset> <action> [shard=<index>] [node=<addr>] [role=primary|replica] [duration=<duration>]".
+type ChaosAction struct {
+	Spec   string
+	Offset time.Duration
+	Action string
+	Shard  int
+	Node   string
+	Role   string
+	// Duration is the DEBUG SLEEP time
+	Duration time.Duration
+}
+
+// ChaosEvent is an executed chaos action, and the impact it had on the message rate.
+type ChaosEvent struct {
+	Spec string `json:"Spec"`
+	Node string `json:"Node"`
+	// Time is the unix time, in milliseconds, the action was sent
+	Time int64 `json:"Time"`
+	// Offset is the time, in seconds, since the first message
+	Offset float64 `json:"Offset"`
+	// Tick is the index of the tick the action happened in, counting the warm-up ticks ( WarmupRateTs ) before the MessageRateTs ones
+	Tick         int     `json:"Tick"`
+	Error        string  `json:"Error,omitempty"`
+	PreEventRate float64 `json:"PreEventRate"`
+	// RecoveryTime is the time, in seconds, until the message rate went back to the pre-event one, or -1 if it never did
+	RecoveryTime float64 `json:"RecoveryTime"`
+}
+
+var chaosEvents []ChaosEvent
+var chaosMutex sync.Mutex
+
+// chaosEnabled is set when the run has a chaos timeline, for the subscribers to reconnect after the actions.
+var chaosEnabled int32
+
+// SetChaosEnabled makes the subscribers of the next run reconnect when their connection is killed or their node goes
+// down, so that the chaos actions measure the recovery of the message rate instead of stopping the subscribers.
+func SetChaosEnabled(enabled bool) {
+	value := int32(0)
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&chaosEnabled, value)
+}
+
+func chaosActive() bool {
+	return atomic.LoadInt32(&chaosEnabled) == 1
+}
+
+// ParseChaosActions parses the chaos timeline, returning the actions sorted by offset.
+func ParseChaosActions(specs []string) (actions []ChaosAction, err error) {
+	for _, spec := range specs {
+		fields := strings.Fields(spec)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid chaos action %q, expected \"<offset> <action> [options]\"", spec)
+		}
+		action := ChaosAction{Spec: spec, Action: fields[1], Duration: time.Second}
+		if action.Offset, err = time.ParseDuration(strings.TrimPrefix(fields[0], "T+")); err != nil {
+			return nil, fmt.Errorf("invalid chaos action %q: %v", spec, err)
+		}
+		found := false
+		for _, choice := range ChaosActionChoices {
+			found = found || choice == action.Action
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid chaos action %q: unsupported action %s ( choices %s )", spec, action.Action, strings.Join(ChaosActionChoices, ","))
+		}
+		for _, option := range fields[2:] {
+			kv := strings.SplitN(option, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid chaos action %q: expected <name>=<value>, got %s", spec, option)
+			}
+			switch kv[0] {
+			case "shard":
+				action.Shard, err = strconv.Atoi(kv[1])
+			case "node":
+				action.Node = kv[1]
+			case "role":
+				if kv[1] != NodeRolePrimary && kv[1] != NodeRoleReplica {
+					err = fmt.Errorf("unsupported role %s ( choices %s,%s )", kv[1], NodeRolePrimary, NodeRoleReplica)
+				}
+				action.Role = kv[1]
+			case "duration":
+				action.Duration, err = time.ParseDuration(kv[1])
+			default:
+				err = fmt.Errorf("unsupported option %s ( choices shard,node,role,duration )", kv[0])
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid chaos action %q: %v", spec, err)
+			}
+		}
+		// CLUSTER FAILOVER is sent to the replica taking over
+		if action.Action == ChaosFailover && action.Role == NodeRolePrimary {
+			return nil, fmt.Errorf("invalid chaos action %q: %s is sent to the replica taking over, role=%s is not supported", spec, ChaosFailover, NodeRolePrimary)
+		}
+		if action.Role == "" {
+			action.Role = NodeRolePrimary
+			if action.Action == ChaosFailover {
+				action.Role = NodeRoleReplica
+			}
+		}
+		actions = append(actions, action)
+	}
+	sort.SliceStable(actions, func(i, j int) bool { return actions[i].Offset < actions[j].Offset })
+	return
+}
+
+// chaosTarget resolves the node of an action using the current topology, or the --host endpoints when it is not a cluster.
+func chaosTarget(action ChaosAction, host string, port string) (string, error) {
+	if action.Node != "" {
+		return action.Node, nil
+	}
+	topology, err := DiscoverTopologyFromArgs(host, port)
+	if err != nil {
+		nodes, _, err := getClusterNodesFromArgs(port, host)
+		if err != nil {
+			return "", err
+		}
+		if action.Shard < 0 || action.Shard >= len(nodes) || action.Role != NodeRolePrimary {
+			return "", fmt.Errorf("no %s node for shard %d among the --host endpoints", action.Role, action.Shard)
+		}
+		return nodes[action.Shard], nil
+	}
+	if action.Shard < 0 || action.Shard >= len(topology.Shards) {
+		return "", fmt.Errorf("shard %d does not exist, the cluster has %d shards", action.Shard, len(topology.Shards))
+	}
+	shard := topology.Shards[action.Shard]
+	if action.Role == NodeRolePrimary {
+		return shard.Primary.Addr, nil
+	}
+	if len(shard.Replicas) == 0 {
+		return "", fmt.Errorf("shard %d has no replicas", action.Shard)
+	}
+	return shard.Replicas[0].Addr, nil
+}
+
+func executeChaos(action ChaosAction, addr string) error {
+	if action.Action == ChaosFailover && sentinelEnabled() {
+		_, err := sentinelDo("SENTINEL", "FAILOVER", sentinelMaster)
+		return err
+	}
+	conn, err := dialResp(addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	switch action.Action {
+	case ChaosFailover:
+		_, err = conn.Do("CLUSTER", "FAILOVER")
+	case ChaosClientKill:
+		_, err = conn.Do("CLIENT", "KILL", "TYPE", "pubsub")
+	case ChaosDebugSleep:
+		_, err = conn.Do("DEBUG", "SLEEP", strconv.FormatFloat(action.Duration.Seconds(), 'f', -1, 64))
+	case ChaosNodeKill:
+		if err = conn.Send("SHUTDOWN", "NOSAVE"); err != nil {
+			return err
+		}
+		// the node closes the connection once it is down
+		if reply, err := conn.ReadReply(); err == nil {
+			if rerr, failed := reply.(respError); failed {
+				return rerr
+			}
+		}
+	}
+	return err
+}
+
+// ChaosRoutine executes the chaos timeline, one action at a time. The offsets start with the first message, as --test-time does.
+func ChaosRoutine(actions []ChaosAction, host string, port string, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	for atomic.LoadUint64(&TotalMessages) == 0 {
+		select {
+		case <-stop:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	start := time.Now()
+	for _, action := range actions {
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Until(start.Add(action.Offset))):
+		}
+		addr, err := chaosTarget(action, host, port)
+		event := ChaosEvent{Spec: action.Spec, Node: addr, Time: time.Now().UnixNano() / int64(time.Millisecond), Offset: time.Since(start).Seconds()}
+		log.Printf("Chaos action %q on node %s at T+%.3fs", action.Spec, addr, event.Offset)
+		if err == nil {
+			err = executeChaos(action, addr)
+		}
+		if err != nil {
+			log.Printf("Chaos action %q failed: %v", action.Spec, err)
+			event.Error = err.Error()
+		}
+		chaosMutex.Lock()
+		chaosEvents = append(chaosEvents, event)
+		chaosMutex.Unlock()
+	}
+}
+
+// ChaosResults places the executed actions on the message rate time series, and computes the time the rate took to
+// reach threshold times the rate of the ticks preceding each action again, with the tick resolution.
+func ChaosResults(messageRateTs []float64, tick time.Duration, threshold float64) []ChaosEvent {
+	chaosMutex.Lock()
+	defer chaosMutex.Unlock()
+	if len(chaosEvents) == 0 {
+		return nil
+	}
+	events := append([]ChaosEvent{}, chaosEvents...)
+	for i := range events {
+		event := &events[i]
+		event.Tick = int(event.Offset / tick.Seconds())
+		event.RecoveryTime = -1
+		from := event.Tick - chaosBaselineTicks
+		if from < 0 {
+			from = 0
+		}
+		if event.Tick > len(messageRateTs) {
+			continue
+		}
+		for _, rate := range messageRateTs[from:event.Tick] {
+			event.PreEventRate += rate / float64(event.Tick-from)
+		}
+		for pos := event.Tick; pos < len(messageRateTs); pos++ {
+			if messageRateTs[pos] >= threshold*event.PreEventRate {
+				event.RecoveryTime = float64(pos+1)*tick.Seconds() - event.Offset
+				if pos == event.Tick {
+					event.RecoveryTime = 0
+				}
+				break
+			}
+		}
+	}
+	return events
+}
+
+func resetChaosEvents() {
+	chaosMutex.Lock()
+	defer chaosMutex.Unlock()
+	chaosEvents = nil
+}
@@ -0,0 +1,262 @@
+package subscribe
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseChaosActions(t *testing.T) {
+	tests := []struct {
+		name    string
+		specs   []string
+		want    []ChaosAction
+		wantErr bool
+	}{
+		{"none", nil, nil, false},
+		{
+			name:  "defaults",
+			specs: []string{"30s client-kill"},
+			want:  []ChaosAction{{Spec: "30s client-kill", Offset: 30 * time.Second, Action: ChaosClientKill, Role: NodeRolePrimary, Duration: time.Second}},
+		},
+		{
+			name:  "options",
+			specs: []string{"T+1m debug-sleep shard=2 role=replica duration=500ms"},
+			want:  []ChaosAction{{Spec: "T+1m debug-sleep shard=2 role=replica duration=500ms", Offset: time.Minute, Action: ChaosDebugSleep, Shard: 2, Role: NodeRoleReplica, Duration: 500 * time.Millisecond}},
+		},
+		{
+			name:  "failover targets the replica",
+			specs: []string{"10s failover shard=1"},
+			want:  []ChaosAction{{Spec: "10s failover shard=1", Offset: 10 * time.Second, Action: ChaosFailover, Shard: 1, Role: NodeRoleReplica, Duration: time.Second}},
+		},
+		{
+			name:  "failover of an explicit replica",
+			specs: []string{"10s failover role=replica"},
+			want:  []ChaosAction{{Spec: "10s failover role=replica", Offset: 10 * time.Second, Action: ChaosFailover, Role: NodeRoleReplica, Duration: time.Second}},
+		},
+		{
+			name:  "sorted by offset",
+			specs: []string{"20s node-kill node=10.0.0.1:7000", "5s client-kill", "20s client-kill"},
+			want: []ChaosAction{
+				{Spec: "5s client-kill", Offset: 5 * time.Second, Action: ChaosClientKill, Role: NodeRolePrimary, Duration: time.Second},
+				{Spec: "20s node-kill node=10.0.0.1:7000", Offset: 20 * time.Second, Action: ChaosNodeKill, Node: "10.0.0.1:7000", Role: NodeRolePrimary, Duration: time.Second},
+				{Spec: "20s client-kill", Offset: 20 * time.Second, Action: ChaosClientKill, Role: NodeRolePrimary, Duration: time.Second},
+			},
+		},
+		{"missing action", []string{"30s"}, nil, true},
+		{"invalid offset", []string{"soon failover"}, nil, true},
+		{"unsupported action", []string{"30s reboot"}, nil, true},
+		{"option without value", []string{"30s failover shard"}, nil, true},
+		{"invalid shard", []string{"30s failover shard=first"}, nil, true},
+		{"unsupported role", []string{"30s client-kill role=any"}, nil, true},
+		{"failover of a primary", []string{"30s failover role=primary"}, nil, true},
+		{"invalid duration", []string{"30s debug-sleep duration=long"}, nil, true},
+		{"unsupported option", []string{"30s client-kill type=pubsub"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseChaosActions(tt.specs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseChaosActions(%q) error = %v, wantErr %v", tt.specs, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseChaosActions(%q) = %+v, want %+v", tt.specs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChaosResults(t *testing.T) {
+	tests := []struct {
+		name         string
+		offset       float64
+		rates        []float64
+		wantTick     int
+		wantPreRate  float64
+		wantRecovery float64
+	}{
+		{"recovers after two ticks", 5.5, []float64{100, 100, 100, 100, 100, 10, 50, 95, 100}, 5, 100, 2.5},
+		{"no impact", 2.5, []float64{100, 100, 100, 100}, 2, 100, 0},
+		{"never recovers", 3.2, []float64{100, 100, 100, 10, 10}, 3, 100, -1},
+		{"baseline limited to the previous ticks", 7.0, []float64{1000, 1000, 100, 100, 100, 100, 100, 0, 100}, 7, 100, 2},
+		{"after the last tick", 12.0, []float64{100, 100}, 12, 0, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetChaosEvents()
+			defer resetChaosEvents()
+			chaosEvents = []ChaosEvent{{Spec: tt.name, Offset: tt.offset}}
+			events := ChaosResults(tt.rates, time.Second, 0.9)
+			if len(events) != 1 {
+				t.Fatalf("ChaosResults returned %d events", len(events))
+			}
+			event := events[0]
+			if event.Tick != tt.wantTick || event.PreEventRate != tt.wantPreRate || event.RecoveryTime != tt.wantRecovery {
+				t.Errorf("tick %d, pre-event rate %f, recovery %f, want %d, %f, %f", event.Tick, event.PreEventRate, event.RecoveryTime, tt.wantTick, tt.wantPreRate, tt.wantRecovery)
+			}
+		})
+	}
+	if events := ChaosResults([]float64{1}, time.Second, 0.9); events != nil {
+		t.Errorf("ChaosResults without actions = %+v", events)
+	}
+}
+
+// fakePubSubNode is a redis node stand-in publishing a message to every subscriber each 10ms, until CLIENT KILL closes
+// their connections.
+type fakePubSubNode struct {
+	listener net.Listener
+	mu       sync.Mutex
+	// subscribers maps the connections to the builder of the messages pushed to them
+	subscribers map[*respConn]func(sequence uint64) []string
+	sequence    uint64
+}
+
+func startFakePubSubNode(t *testing.T) *fakePubSubNode {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &fakePubSubNode{listener: listener, subscribers: map[*respConn]func(sequence uint64) []string{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go node.serve(&respConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)})
+		}
+	}()
+	go func() {
+		tick := time.NewTicker(10 * time.Millisecond)
+		defer tick.Stop()
+		for range tick.C {
+			node.mu.Lock()
+			if node.listener == nil {
+				node.mu.Unlock()
+				return
+			}
+			node.sequence++
+			for conn, message := range node.subscribers {
+				if conn.Send(message(node.sequence)...) != nil {
+					delete(node.subscribers, conn)
+				}
+			}
+			node.mu.Unlock()
+		}
+	}()
+	return node
+}
+
+func (node *fakePubSubNode) close() {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.listener.Close()
+	node.listener = nil
+	for conn := range node.subscribers {
+		conn.Close()
+	}
+}
+
+func (node *fakePubSubNode) serve(conn *respConn) {
+	defer conn.Close()
+	for {
+		request, err := conn.ReadReply()
+		if err != nil {
+			return
+		}
+		args := []string{}
+		for _, arg := range request.([]interface{}) {
+			args = append(args, arg.(string))
+		}
+		node.mu.Lock()
+		switch args[0] {
+		case "SUBSCRIBE":
+			channel := args[1]
+			conn.Send("subscribe", channel, "1")
+			node.subscribers[conn] = func(sequence uint64) []string {
+				return []string{"message", channel, newSequencedPayload(64, sequence)}
+			}
+		case "PSUBSCRIBE":
+			for _, pattern := range args[1:] {
+				conn.Send("psubscribe", pattern, "1")
+			}
+			// the keyevent notifications carry the key
+			pattern := args[1]
+			node.subscribers[conn] = func(sequence uint64) []string {
+				return []string{"pmessage", pattern, "__keyevent@0__:set", "chaos1"}
+			}
+		case "CLIENT":
+			if args[1] == "KILL" {
+				for subscriber := range node.subscribers {
+					subscriber.Close()
+				}
+				node.subscribers = map[*respConn]func(sequence uint64) []string{}
+			}
+			conn.Send("OK")
+		default:
+			conn.Send("OK")
+		}
+		node.mu.Unlock()
+	}
+}
+
+func TestChaosClientKillRecovery(t *testing.T) {
+	tests := []struct {
+		name  string
+		start func(addr string, stop chan struct{}, wg *sync.WaitGroup)
+	}{
+		{"resp subscriber", func(addr string, stop chan struct{}, wg *sync.WaitGroup) {
+			SubscriberRoutine(RedisClientResp, addr, "", RedisCredentials{}, "subscriber#1-chaos1", "chaos1", false, stop, wg)
+		}},
+		{"keyspace subscriber", func(addr string, stop chan struct{}, wg *sync.WaitGroup) {
+			KeyspaceSubscriberRoutine(addr, "subscriber#1-keyspace-node0", []string{"__keyevent@*__:*"}, "chaos", true, false, stop, wg)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := startFakePubSubNode(t)
+			addr := node.listener.Addr().String()
+			defer node.close()
+			ResetMetrics()
+			defer ResetMetrics()
+			SetChaosEnabled(true)
+			defer SetChaosEnabled(false)
+			actions, err := ParseChaosActions([]string{"300ms client-kill node=" + addr})
+			if err != nil {
+				t.Fatal(err)
+			}
+			stop := make(chan struct{})
+			wg := sync.WaitGroup{}
+			wg.Add(2)
+			go tt.start(addr, stop, &wg)
+			go ChaosRoutine(actions, "", "", stop, &wg)
+			waitFor(t, "the first message", func() bool { return atomic.LoadUint64(&TotalMessages) > 0 })
+			// the message rate of 100ms ticks, starting with the first message as the chaos offsets
+			tick := 100 * time.Millisecond
+			rates := []float64{}
+			previous := atomic.LoadUint64(&TotalMessages)
+			for len(rates) < 15 {
+				time.Sleep(tick)
+				total := atomic.LoadUint64(&TotalMessages)
+				rates = append(rates, float64(total-previous)/tick.Seconds())
+				previous = total
+			}
+			close(stop)
+			wg.Wait()
+			events := ChaosResults(rates, tick, 0.5)
+			if len(events) != 1 || events[0].Error != "" {
+				t.Fatalf("chaos events %+v, want a single successful client-kill", events)
+			}
+			if events[0].RecoveryTime < 0 {
+				t.Errorf("no recovery time recorded after the client-kill, message rates %v", rates)
+			}
+			if reconnects := DeliveryStats()["subscriber-reconnects"]; reconnects == 0 {
+				t.Errorf("the subscriber did not reconnect after the client-kill")
+			}
+		})
+	}
+}
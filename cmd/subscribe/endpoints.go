@@ -0,0 +1,172 @@
+package subscribe
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Endpoint is a single redis node given through --host and --port.
+type Endpoint struct {
+	// Network is tcp or unix
+	Network string
+	// Addr is host:port, or the socket path for unix endpoints
+	Addr string
+	// Credentials holds the user and password of the URI, if any
+	Credentials *RedisCredentials
+	// TLS is set for rediss:// endpoints
+	TLS bool
+}
+
+var endpoints = map[string]Endpoint{}
+var defaultEndpoint *Endpoint
+var endpointsMutex sync.Mutex
+
+// ParseEndpoints parses the comma separated --host and --port values. Each host can be a redis://, rediss://
+// or unix:// URI, a host:port pair (use [addr]:port for IPv6) or a plain host. Hosts without a port use the
+// --port entry with the same index, or the single --port value for every host.
+func ParseEndpoints(host string, port string) (parsed []Endpoint, err error) {
+	hosts := strings.Split(host, ",")
+	ports := strings.Split(port, ",")
+	if len(ports) != 1 && len(ports) != len(hosts) {
+		return nil, fmt.Errorf("--port has %d entries but --host has %d. Use a single port for every host, or one port per host", len(ports), len(hosts))
+	}
+	for idx, entry := range hosts {
+		entry = strings.TrimSpace(entry)
+		defaultPort := strings.TrimSpace(ports[0])
+		if len(ports) > 1 {
+			defaultPort = strings.TrimSpace(ports[idx])
+		}
+		var endpoint Endpoint
+		if strings.Contains(entry, "://") {
+			endpoint, err = parseEndpointURI(entry, defaultPort)
+		} else {
+			endpoint, err = parseEndpointAddr(entry, defaultPort)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid --host entry %q: %v", entry, err)
+		}
+		parsed = append(parsed, endpoint)
+	}
+	return
+}
+
+func parseEndpointURI(entry string, defaultPort string) (endpoint Endpoint, err error) {
+	u, err := url.Parse(entry)
+	if err != nil {
+		return
+	}
+	if u.User != nil {
+		password, hasPassword := u.User.Password()
+		username := u.User.Username()
+		// redis://secret@host uses secret as the password of the default user
+		if !hasPassword {
+			username, password = "", username
+		}
+		endpoint.Credentials = &RedisCredentials{Username: username, Password: password}
+	}
+	switch u.Scheme {
+	case "unix":
+		if u.Path == "" {
+			return endpoint, fmt.Errorf("missing socket path ( e.g. unix:///run/redis.sock )")
+		}
+		endpoint.Network, endpoint.Addr = "unix", u.Path
+		return endpoint, nil
+	case "redis", "rediss":
+		endpoint.TLS = u.Scheme == "rediss"
+	default:
+		return endpoint, fmt.Errorf("unsupported scheme %s ( choices redis,rediss,unix )", u.Scheme)
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" && db != "0" {
+		return endpoint, fmt.Errorf("selecting database %s is not supported, pub/sub is not scoped by database", db)
+	}
+	if u.Hostname() == "" {
+		return endpoint, fmt.Errorf("missing host")
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	if err = validatePort(port); err != nil {
+		return
+	}
+	endpoint.Network, endpoint.Addr = "tcp", net.JoinHostPort(u.Hostname(), port)
+	return endpoint, nil
+}
+
+func parseEndpointAddr(entry string, defaultPort string) (endpoint Endpoint, err error) {
+	host, port := entry, defaultPort
+	// a bare IPv6 address has several colons and no brackets
+	if strings.HasPrefix(entry, "[") || strings.Count(entry, ":") == 1 {
+		if host, port, err = net.SplitHostPort(entry); err != nil {
+			// [::1] without a port
+			if strings.HasPrefix(entry, "[") && strings.HasSuffix(entry, "]") {
+				host, port, err = strings.Trim(entry, "[]"), defaultPort, nil
+			} else {
+				return
+			}
+		}
+	}
+	if host == "" {
+		return endpoint, fmt.Errorf("missing host")
+	}
+	if err = validatePort(port); err != nil {
+		return
+	}
+	endpoint.Network, endpoint.Addr = "tcp", net.JoinHostPort(host, port)
+	return endpoint, nil
+}
+
+func validatePort(port string) error {
+	value, err := strconv.Atoi(port)
+	if err != nil || value < 1 || value > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// SetEndpoints parses and registers the --host and --port endpoints, so that the connections to each of them use
+// their URI settings. Nodes discovered afterwards (e.g. from the cluster topology) use the settings of the first endpoint.
+func SetEndpoints(host string, port string) ([]Endpoint, error) {
+	parsed, err := ParseEndpoints(host, port)
+	if err != nil {
+		return nil, err
+	}
+	// sweeps, repetitions and find-max probes register the endpoints again for each run
+	resetAddresses()
+	endpointsMutex.Lock()
+	defer endpointsMutex.Unlock()
+	endpoints = map[string]Endpoint{}
+	for _, endpoint := range parsed {
+		endpoints[endpoint.Addr] = endpoint
+		recordAddress(endpoint.Addr, "")
+	}
+	first := parsed[0]
+	defaultEndpoint = &first
+	return parsed, nil
+}
+
+// endpointFor returns the settings used to connect to a node.
+func endpointFor(addr string) Endpoint {
+	endpointsMutex.Lock()
+	defer endpointsMutex.Unlock()
+	if endpoint, found := endpoints[addr]; found {
+		return endpoint
+	}
+	endpoint := Endpoint{Network: "tcp", Addr: addr}
+	if defaultEndpoint != nil {
+		endpoint.Credentials, endpoint.TLS = defaultEndpoint.Credentials, defaultEndpoint.TLS
+	}
+	return endpoint
+}
+
+// credentialsFor returns the credentials used to connect to a node: the ones of its URI, or --user and --password.
+func credentialsFor(addr string) RedisCredentials {
+	if endpoint := endpointFor(addr); endpoint.Credentials != nil {
+		return *endpoint.Credentials
+	}
+	return RedisCredentials{Username: redisConnection.Username, Password: redisConnection.Password}
+}
@@ -0,0 +1,76 @@
+package subscribe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEndpoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		port    string
+		want    []Endpoint
+		wantErr bool
+	}{
+		{"plain host", "127.0.0.1", "6379", []Endpoint{{Network: "tcp", Addr: "127.0.0.1:6379"}}, false},
+		{"host and port", "redis.local:7000", "6379", []Endpoint{{Network: "tcp", Addr: "redis.local:7000"}}, false},
+		{"single port for every host", "a,b", "7000", []Endpoint{{Network: "tcp", Addr: "a:7000"}, {Network: "tcp", Addr: "b:7000"}}, false},
+		{"one port per host", "a, b", "7000, 7001", []Endpoint{{Network: "tcp", Addr: "a:7000"}, {Network: "tcp", Addr: "b:7001"}}, false},
+		{"bare ipv6", "::1", "6379", []Endpoint{{Network: "tcp", Addr: "[::1]:6379"}}, false},
+		{"bracketed ipv6 without port", "[::1]", "6379", []Endpoint{{Network: "tcp", Addr: "[::1]:6379"}}, false},
+		{"bracketed ipv6 with port", "[::1]:7000", "6379", []Endpoint{{Network: "tcp", Addr: "[::1]:7000"}}, false},
+		{"redis uri", "redis://h:7000", "6379", []Endpoint{{Network: "tcp", Addr: "h:7000"}}, false},
+		{"redis uri default port", "redis://h", "6380", []Endpoint{{Network: "tcp", Addr: "h:6380"}}, false},
+		{"redis uri database 0", "redis://h:7000/0", "6379", []Endpoint{{Network: "tcp", Addr: "h:7000"}}, false},
+		{"rediss uri with user", "rediss://bob:secret@h:7000", "6379", []Endpoint{{Network: "tcp", Addr: "h:7000", TLS: true, Credentials: &RedisCredentials{Username: "bob", Password: "secret"}}}, false},
+		{"redis uri with password only", "redis://secret@h", "6379", []Endpoint{{Network: "tcp", Addr: "h:6379", Credentials: &RedisCredentials{Password: "secret"}}}, false},
+		{"unix socket", "unix:///run/redis.sock", "6379", []Endpoint{{Network: "unix", Addr: "/run/redis.sock"}}, false},
+		{"mixed", "unix:///s.sock,h", "6379", []Endpoint{{Network: "unix", Addr: "/s.sock"}, {Network: "tcp", Addr: "h:6379"}}, false},
+		{"port count mismatch", "a,b,c", "1,2", nil, true},
+		{"invalid port", "h", "abc", nil, true},
+		{"port out of range", "h:70000", "6379", nil, true},
+		{"empty host", ":7000", "6379", nil, true},
+		{"unsupported scheme", "http://h", "6379", nil, true},
+		{"unix socket without path", "unix://", "6379", nil, true},
+		{"uri without host", "redis://:7000", "6379", nil, true},
+		{"database selection", "redis://h/2", "6379", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEndpoints(tt.host, tt.port)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEndpoints(%q, %q) error = %v, wantErr %v", tt.host, tt.port, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseEndpoints(%q, %q) = %+v, want %+v", tt.host, tt.port, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEndpointSettings(t *testing.T) {
+	if _, err := SetEndpoints("rediss://bob:secret@h1:7000,h2", "6379"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetEndpoints("127.0.0.1", "6379")
+	tests := []struct {
+		addr            string
+		wantCredentials RedisCredentials
+		wantTLS         bool
+	}{
+		{"h1:7000", RedisCredentials{Username: "bob", Password: "secret"}, true},
+		// endpoints without URI settings use --user and --password
+		{"h2:6379", RedisCredentials{Username: redisConnection.Username, Password: redisConnection.Password}, false},
+		// discovered nodes inherit the settings of the first endpoint
+		{"10.0.0.1:7001", RedisCredentials{Username: "bob", Password: "secret"}, true},
+	}
+	for _, tt := range tests {
+		if got := credentialsFor(tt.addr); got != tt.wantCredentials {
+			t.Errorf("credentialsFor(%s) = %+v, want %+v", tt.addr, got, tt.wantCredentials)
+		}
+		if got := tlsConfigFor(tt.addr) != nil; got != tt.wantTLS {
+			t.Errorf("tlsConfigFor(%s) set = %v, want %v", tt.addr, got, tt.wantTLS)
+		}
+	}
+}
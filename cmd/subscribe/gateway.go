@@ -0,0 +1,173 @@
+package subscribe
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+var GatewayWebsocket string = "websocket"
+var GatewaySSE string = "sse"
+
+// gatewayURL replaces the {channel} placeholder of an url template.
+func gatewayURL(template string, channel string) string {
+	return strings.Replace(template, "{channel}", url.PathEscape(channel), -1)
+}
+
+func onGatewayMessage(channel string, message string, printMessages bool) {
+	if printMessages {
+		fmt.Println(fmt.Sprintf("received frame for channel %s. Message: %s", channel, message))
+	}
+	recordPayloadLatency(message)
+	atomic.AddUint64(&TotalMessages, 1)
+}
+
+// newGatewayClient returns an http.Client with its own keep-alive pool, sized for the given number of
+// concurrent connections to the gateway, leaving http.DefaultTransport untouched.
+func newGatewayClient(connections int) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = connections
+	transport.MaxIdleConnsPerHost = connections
+	return &http.Client{Transport: transport}
+}
+
+func WebsocketSubscriberRoutine(endpoint string, channel string, printMessages bool, subscribed *sync.WaitGroup, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	conn, _, err := websocket.DefaultDialer.Dial(endpoint, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	subscribed.Done()
+	go func() {
+		<-stop
+		conn.Close()
+	}()
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		onGatewayMessage(channel, string(message), printMessages)
+	}
+}
+
+func SSESubscriberRoutine(client *http.Client, endpoint string, channel string, printMessages bool, subscribed *sync.WaitGroup, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatal(fmt.Errorf("unexpected status %s while connecting to %s", resp.Status, endpoint))
+	}
+	subscribed.Done()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	// each event is made of "data:" lines terminated by an empty line
+	reader := bufio.NewReader(resp.Body)
+	data := []string{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if len(data) > 0 {
+				onGatewayMessage(channel, strings.Join(data, "\n"), printMessages)
+				data = data[:0]
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "data:") {
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+}
+
+func GatewayPublisherRoutine(client *http.Client, endpoint string, dataSize int, publishRate int, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	tick := publishTicker(publishRate)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			resp, err := client.Post(endpoint, "text/plain", strings.NewReader(newPayload(dataSize)))
+			if err != nil {
+				log.Printf("Error while publishing to %s: %v", endpoint, err)
+				continue
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func GatewayLogic(debug int, stopChan chan struct{}, wg *sync.WaitGroup, protocol string, url_template string, publish_url_template string, embeddedGateway string, channel_maximum int, channel_minimum int, subscribers_per_channel int, subscribe_prefix string, data_size int, publish_rate int) {
+	printMessages := false
+	if debug >= 2 {
+		printMessages = true
+	}
+	if embeddedGateway != "" {
+		startEchoGateway(embeddedGateway, stopChan, wg)
+	}
+	channels := channel_maximum - channel_minimum + 1
+	// keep the HTTP keep-alive pool large enough for the SSE subscribers and the built-in publishers
+	client := newGatewayClient(channels * (subscribers_per_channel + 1))
+	subscribed := sync.WaitGroup{}
+
+	startSubscribers(stopChan, wg, channel_maximum, channel_minimum, subscribers_per_channel, func(channel_id int, channel_subscriber_number int, stop chan struct{}) {
+		channel := fmt.Sprintf("%s%d", subscribe_prefix, channel_id)
+		endpoint := gatewayURL(url_template, channel)
+		if debug >= 1 {
+			log.Printf("Channel %s %s client #%d using %s", channel, protocol, channel_subscriber_number, endpoint)
+		}
+		wg.Add(1)
+		subscribed.Add(1)
+		if protocol == GatewaySSE {
+			go SSESubscriberRoutine(client, endpoint, channel, printMessages, &subscribed, stop, wg)
+		} else {
+			go WebsocketSubscriberRoutine(endpoint, channel, printMessages, &subscribed, stop, wg)
+		}
+	})
+
+	if publish_rate <= 0 {
+		return
+	}
+	// the gateway drops messages for channels without listeners, so only publish once every subscriber is connected
+	subscribed.Wait()
+	for channel_id := channel_minimum; channel_id <= channel_maximum; channel_id++ {
+		channel := fmt.Sprintf("%s%d", subscribe_prefix, channel_id)
+		wg.Add(1)
+		go GatewayPublisherRoutine(client, gatewayURL(publish_url_template, channel), data_size, publish_rate, stopChan, wg)
+	}
+}
@@ -0,0 +1,152 @@
+package subscribe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// echoGateway is a minimal WebSocket/SSE fan-out gateway: every message POSTed to /publish/<channel>
+// is echoed to all the clients connected to /ws/<channel> and /sse/<channel>.
+type echoGateway struct {
+	mu       sync.RWMutex
+	channels map[string]map[chan string]struct{}
+	upgrader websocket.Upgrader
+	server   *http.Server
+}
+
+func newEchoGateway(addr string) *echoGateway {
+	g := &echoGateway{channels: map[string]map[chan string]struct{}{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/publish/", g.handlePublish)
+	mux.HandleFunc("/ws/", g.handleWebsocket)
+	mux.HandleFunc("/sse/", g.handleSSE)
+	g.server = &http.Server{Addr: addr, Handler: mux}
+	return g
+}
+
+func (g *echoGateway) register(channel string) chan string {
+	ch := make(chan string, 1024)
+	g.mu.Lock()
+	if g.channels[channel] == nil {
+		g.channels[channel] = map[chan string]struct{}{}
+	}
+	g.channels[channel][ch] = struct{}{}
+	g.mu.Unlock()
+	return ch
+}
+
+func (g *echoGateway) unregister(channel string, ch chan string) {
+	g.mu.Lock()
+	delete(g.channels[channel], ch)
+	g.mu.Unlock()
+}
+
+func (g *echoGateway) handlePublish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	channel := strings.TrimPrefix(r.URL.Path, "/publish/")
+	receivers := 0
+	g.mu.RLock()
+	for ch := range g.channels[channel] {
+		select {
+		case ch <- string(body):
+			receivers++
+		default:
+			// slow client, drop the message as a real gateway would
+		}
+	}
+	g.mu.RUnlock()
+	fmt.Fprintf(w, "%d", receivers)
+}
+
+func (g *echoGateway) handleWebsocket(w http.ResponseWriter, r *http.Request) {
+	channel := strings.TrimPrefix(r.URL.Path, "/ws/")
+	// register before completing the handshake, so the client is subscribed once its dial returns
+	ch := g.register(channel)
+	defer g.unregister(channel, ch)
+	conn, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	closed := make(chan struct{})
+	go func() {
+		// drain control frames and detect the client going away
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case message := <-ch:
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+func (g *echoGateway) handleSSE(w http.ResponseWriter, r *http.Request) {
+	channel := strings.TrimPrefix(r.URL.Path, "/sse/")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	ch := g.register(channel)
+	defer g.unregister(channel, ch)
+	flusher.Flush()
+	for {
+		select {
+		case message := <-ch:
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", message); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// startEchoGateway runs the echo gateway in-process until stop is closed.
+func startEchoGateway(addr string, stop chan struct{}, wg *sync.WaitGroup) {
+	g := newEchoGateway(addr)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := g.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	go func() {
+		<-stop
+		g.server.Close()
+	}()
+	log.Printf("Started embedded echo gateway at %s", addr)
+}
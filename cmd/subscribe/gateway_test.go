@@ -0,0 +1,63 @@
+package subscribe
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGatewayLogicEmbeddedGateway(t *testing.T) {
+	tests := []struct {
+		protocol string
+		path     string
+	}{
+		{GatewayWebsocket, "ws://%s/ws/{channel}"},
+		{GatewaySSE, "http://%s/sse/{channel}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.protocol, func(t *testing.T) {
+			addr := freeAddress(t)
+			defaultIdle := http.DefaultTransport.(*http.Transport).MaxIdleConnsPerHost
+			url_template := strings.Replace(tt.path, "%s", addr, 1)
+			publish_url_template := "http://" + addr + "/publish/{channel}"
+			var receivers string
+			runUntilMessages(t, 20, func(stopChan chan struct{}, wg *sync.WaitGroup) {
+				GatewayLogic(0, stopChan, wg, tt.protocol, url_template, publish_url_template, addr, 2, 1, 2, "gateway-test-", 128, 50)
+				// every subscriber must be registered by the time the publishers start
+				resp, err := http.Post(gatewayURL(publish_url_template, "gateway-test-1"), "text/plain", strings.NewReader(newPayload(128)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				receivers = string(body)
+			})
+			if receivers != "2" {
+				t.Errorf("publish reached %s subscribers, want 2", receivers)
+			}
+			if got := http.DefaultTransport.(*http.Transport).MaxIdleConnsPerHost; got != defaultIdle {
+				t.Errorf("http.DefaultTransport MaxIdleConnsPerHost changed from %d to %d", defaultIdle, got)
+			}
+		})
+	}
+}
+
+func TestGatewayURL(t *testing.T) {
+	tests := []struct {
+		template string
+		channel  string
+		want     string
+	}{
+		{"ws://127.0.0.1:8080/ws/{channel}", "channel-1", "ws://127.0.0.1:8080/ws/channel-1"},
+		{"http://127.0.0.1:8080/sse/{channel}?topic={channel}", "a", "http://127.0.0.1:8080/sse/a?topic=a"},
+		{"http://127.0.0.1:8080/publish/{channel}", "a b/c", "http://127.0.0.1:8080/publish/a%20b%2Fc"},
+		{"http://127.0.0.1:8080/publish", "a", "http://127.0.0.1:8080/publish"},
+	}
+	for _, tt := range tests {
+		if got := gatewayURL(tt.template, tt.channel); got != tt.want {
+			t.Errorf("gatewayURL(%q, %q) = %q, want %q", tt.template, tt.channel, got, tt.want)
+		}
+	}
+}
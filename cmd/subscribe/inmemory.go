@@ -0,0 +1,149 @@
+package subscribe
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// inMemoryQueueSize is the buffer of each subscriber Go channel.
+const inMemoryQueueSize = 1024
+
+// inMemoryChannel holds the queues of the subscribers of a channel, which can come and go during the run.
+type inMemoryChannel struct {
+	mu          sync.RWMutex
+	subscribers map[chan string]chan struct{}
+	// subscribed is closed, and replaced, when a subscriber is added
+	subscribed chan struct{}
+}
+
+func newInMemoryChannel() *inMemoryChannel {
+	return &inMemoryChannel{subscribers: map[chan string]chan struct{}{}, subscribed: make(chan struct{})}
+}
+
+func (c *inMemoryChannel) subscribe(messages chan string, stop chan struct{}) {
+	c.mu.Lock()
+	c.subscribers[messages] = stop
+	close(c.subscribed)
+	c.subscribed = make(chan struct{})
+	c.mu.Unlock()
+}
+
+func (c *inMemoryChannel) unsubscribe(messages chan string) {
+	c.mu.Lock()
+	delete(c.subscribers, messages)
+	c.mu.Unlock()
+}
+
+// calibrationPublishRate is the per channel rate at which the calibration latency floor is measured,
+// low enough for the subscriber queues to stay empty.
+const calibrationPublishRate = 10
+
+func InMemorySubscriberRoutine(messages chan string, channel string, printMessages bool, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	for {
+		select {
+		case message := <-messages:
+			if printMessages {
+				fmt.Println(fmt.Sprintf("received message in channel %s. Message: %s", channel, message))
+			}
+			recordPayloadLatency(message)
+			atomic.AddUint64(&TotalMessages, 1)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// InMemoryPublisherRoutine fans out each message to every subscriber of the channel.
+// With publishRate 0 it publishes as fast as the subscribers are able to consume.
+func InMemoryPublisherRoutine(channel *inMemoryChannel, dataSize int, publishRate int, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	var tick <-chan time.Time
+	if publishRate > 0 {
+		ticker := publishTicker(publishRate)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-stop:
+				return
+			}
+		}
+		channel.mu.RLock()
+		if len(channel.subscribers) == 0 {
+			// nothing to publish to until a subscriber is added
+			subscribed := channel.subscribed
+			channel.mu.RUnlock()
+			select {
+			case <-subscribed:
+			case <-stop:
+				return
+			}
+			continue
+		}
+		payload := newPayload(dataSize)
+		for subscriber, unsubscribed := range channel.subscribers {
+			select {
+			case subscriber <- payload:
+			case <-unsubscribed:
+			case <-stop:
+				channel.mu.RUnlock()
+				return
+			}
+		}
+		channel.mu.RUnlock()
+	}
+}
+
+func InMemoryLogic(debug int, stopChan chan struct{}, wg *sync.WaitGroup, channel_maximum int, channel_minimum int, subscribers_per_channel int, subscribe_prefix string, data_size int, publish_rate int) {
+	printMessages := false
+	if debug >= 2 {
+		printMessages = true
+	}
+	channels := map[int]*inMemoryChannel{}
+	for channel_id := channel_minimum; channel_id <= channel_maximum; channel_id++ {
+		channels[channel_id] = newInMemoryChannel()
+	}
+	startSubscribers(stopChan, wg, channel_maximum, channel_minimum, subscribers_per_channel, func(channel_id int, channel_subscriber_number int, stop chan struct{}) {
+		messages := make(chan string, inMemoryQueueSize)
+		channels[channel_id].subscribe(messages, stop)
+		wg.Add(1)
+		go func() {
+			defer channels[channel_id].unsubscribe(messages)
+			InMemorySubscriberRoutine(messages, fmt.Sprintf("%s%d", subscribe_prefix, channel_id), printMessages, stop, wg)
+		}()
+	})
+	for channel_id := channel_minimum; channel_id <= channel_maximum; channel_id++ {
+		wg.Add(1)
+		go InMemoryPublisherRoutine(channels[channel_id], data_size, publish_rate, stopChan, wg)
+	}
+	if debug >= 1 {
+		log.Printf("Started %d in-memory channels with %d subscribers each", channel_maximum-channel_minimum+1, subscribers_per_channel)
+	}
+}
+
+// Calibrate runs the in-memory pipeline for the given duration, returning the message rate and latency
+// percentiles (in milliseconds) the tool itself is able to sustain on the current machine.
+// The first half of the duration measures the maximum throughput with unthrottled publishers, whose
+// latencies are dominated by the full subscriber queues. The second half measures the latency floor
+// at a low fixed publish rate.
+// The global metrics are reset afterwards, so it can be called before a regular run.
+func Calibrate(duration time.Duration, channel_maximum int, channel_minimum int, subscribers_per_channel int, data_size int) (messageRate float64, p50 float64, p99 float64) {
+	throughput := MeasureRun(duration/2, func(stopChan chan struct{}, wg *sync.WaitGroup) {
+		InMemoryLogic(0, stopChan, wg, channel_maximum, channel_minimum, subscribers_per_channel, "calibration-", data_size, 0)
+	})
+	latency := MeasureRun(duration-duration/2, func(stopChan chan struct{}, wg *sync.WaitGroup) {
+		InMemoryLogic(0, stopChan, wg, channel_maximum, channel_minimum, subscribers_per_channel, "calibration-", data_size, calibrationPublishRate)
+	})
+	return throughput.MessageRate, latency.LatencyP50, latency.LatencyP99
+}
@@ -0,0 +1,72 @@
+package subscribe
+
+import (
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInMemoryLogic(t *testing.T) {
+	runUntilMessages(t, 100, func(stopChan chan struct{}, wg *sync.WaitGroup) {
+		InMemoryLogic(0, stopChan, wg, 2, 1, 2, "inmemory-test-", 128, 0)
+	})
+}
+
+func TestCalibrate(t *testing.T) {
+	rate, p50, p99 := Calibrate(time.Second, 2, 1, 2, 128)
+	if rate <= 0 {
+		t.Errorf("calibration message rate %f, want > 0", rate)
+	}
+	if p50 <= 0 || p99 < p50 {
+		t.Errorf("calibration latency p50 %f p99 %f, want 0 < p50 <= p99", p50, p99)
+	}
+	// the latency floor is measured at a low rate, so it must not include the unthrottled queueing delay
+	if p99 > 100 {
+		t.Errorf("calibration latency p99 %fms includes queueing delay", p99)
+	}
+	if total := TotalMessages; total != 0 {
+		t.Errorf("calibration left %d messages in the global metrics", total)
+	}
+}
+
+func TestInMemoryPublisherWithoutSubscribers(t *testing.T) {
+	stopChan := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	channel := newInMemoryChannel()
+	go InMemoryPublisherRoutine(channel, 128, 0, stopChan, &wg)
+	// the publisher must wait for a subscriber instead of spinning
+	before := processCPUTime(t)
+	time.Sleep(500 * time.Millisecond)
+	if used := processCPUTime(t) - before; used > 250*time.Millisecond {
+		t.Errorf("the unthrottled publisher used %s of CPU in 500ms without subscribers", used)
+	}
+	messages := make(chan string, 1)
+	channel.subscribe(messages, stopChan)
+	select {
+	case <-messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message published once a subscriber was added")
+	}
+	close(stopChan)
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the unthrottled publisher of a channel without subscribers did not stop")
+	}
+}
+
+// processCPUTime is the user and system CPU time used by the test process so far.
+func processCPUTime(t *testing.T) time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		t.Fatal(err)
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
@@ -0,0 +1,101 @@
+package subscribe
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// liveControlInterval is how often the publishers and the subscriber launchers follow the live settings.
+const liveControlInterval = 100 * time.Millisecond
+
+// livePublishRate holds the float64 bits of the publish rate the built-in publishers follow, 0 when not set.
+var livePublishRate uint64
+
+// liveSubscribersPerChannel is the number of subscribers each channel should have, 0 when not set.
+var liveSubscribersPerChannel int64
+
+// liveDataSize is the payload size of the messages sent by the built-in publishers, 0 when not set.
+var liveDataSize int64
+
+// SetLivePublishRate makes the built-in publishers of the next runs send rate messages per second each, and changes
+// the rate of the running ones that were started with a live rate. A rate of 0 disables the live rate.
+func SetLivePublishRate(rate float64) {
+	atomic.StoreUint64(&livePublishRate, math.Float64bits(rate))
+}
+
+func currentLivePublishRate() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&livePublishRate))
+}
+
+// SetLiveSubscribersPerChannel starts or stops subscribers of the running system to reach count subscribers per
+// channel, when the system was started with a live subscriber count. A count of 0 disables the live subscriber count.
+func SetLiveSubscribersPerChannel(count int) {
+	atomic.StoreInt64(&liveSubscribersPerChannel, int64(count))
+}
+
+// SetLiveDataSize makes the built-in publishers send size bytes messages, from their next message on, instead of the
+// data size they were started with. A size of 0 disables the live data size.
+func SetLiveDataSize(size int) {
+	atomic.StoreInt64(&liveDataSize, int64(size))
+}
+
+// payloadSize is the size of the next message of a publisher started with dataSize.
+func payloadSize(dataSize int) int {
+	if size := int(atomic.LoadInt64(&liveDataSize)); size > 0 {
+		return size
+	}
+	return dataSize
+}
+
+// subscriberLauncher starts the subscriber number of a channel, which runs until stop is closed.
+type subscriberLauncher func(channel_id int, subscriber_number int, stop chan struct{})
+
+// startSubscribers launches subscribers_per_channel subscribers on every channel. When a live subscriber count is set,
+// the last subscribers of every channel are then started or stopped to follow it, until stopChan is closed.
+func startSubscribers(stopChan chan struct{}, wg *sync.WaitGroup, channel_maximum int, channel_minimum int, subscribers_per_channel int, launch subscriberLauncher) {
+	if atomic.LoadInt64(&liveSubscribersPerChannel) == 0 {
+		for channel_id := channel_minimum; channel_id <= channel_maximum; channel_id++ {
+			for subscriber_number := 1; subscriber_number <= subscribers_per_channel; subscriber_number++ {
+				launch(channel_id, subscriber_number, stopChan)
+			}
+		}
+		return
+	}
+	// every subscriber number has its own stop channel, closed to remove it from all the channels
+	levels := []chan struct{}{}
+	resize := func(count int) {
+		for len(levels) < count {
+			level := make(chan struct{})
+			levels = append(levels, level)
+			for channel_id := channel_minimum; channel_id <= channel_maximum; channel_id++ {
+				launch(channel_id, len(levels), level)
+			}
+		}
+		for len(levels) > count {
+			close(levels[len(levels)-1])
+			levels = levels[:len(levels)-1]
+		}
+	}
+	resize(subscribers_per_channel)
+	wg.Add(1)
+	go func() {
+		// tell the caller we've stopped
+		defer wg.Done()
+
+		tick := time.NewTicker(liveControlInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				if count := int(atomic.LoadInt64(&liveSubscribersPerChannel)); count > 0 {
+					resize(count)
+				}
+			case <-stopChan:
+				resize(0)
+				return
+			}
+		}
+	}()
+}
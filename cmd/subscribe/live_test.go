@@ -0,0 +1,146 @@
+package subscribe
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStartSubscribers(t *testing.T) {
+	tests := []struct {
+		name   string
+		live   bool
+		counts []int
+	}{
+		{"fixed", false, []int{2}},
+		{"live growing", true, []int{2, 5}},
+		{"live shrinking", true, []int{3, 1}},
+		{"live back and forth", true, []int{1, 4, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.live {
+				SetLiveSubscribersPerChannel(tt.counts[0])
+				defer SetLiveSubscribersPerChannel(0)
+			}
+			var active, started int64
+			stopChan := make(chan struct{})
+			wg := sync.WaitGroup{}
+			startSubscribers(stopChan, &wg, 3, 1, tt.counts[0], func(channel_id int, subscriber_number int, stop chan struct{}) {
+				atomic.AddInt64(&active, 1)
+				atomic.AddInt64(&started, 1)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-stop
+					atomic.AddInt64(&active, -1)
+				}()
+			})
+			for _, count := range tt.counts {
+				SetLiveSubscribersPerChannel(count)
+				waitFor(t, "the subscribers to follow the live count", func() bool { return atomic.LoadInt64(&active) == int64(3*count) })
+			}
+			close(stopChan)
+			wg.Wait()
+			if active != 0 {
+				t.Errorf("%d subscribers still running after the stop", active)
+			}
+			if !tt.live && started != 6 {
+				t.Errorf("started %d subscribers, want 6", started)
+			}
+		})
+	}
+}
+
+func TestLivePublishRate(t *testing.T) {
+	countTicks := func(pacer *publishPacer, during time.Duration) int {
+		ticks := 0
+		deadline := time.After(during)
+		for {
+			select {
+			case <-pacer.C:
+				ticks++
+			case <-deadline:
+				return ticks
+			}
+		}
+	}
+	SetLivePublishRate(20)
+	defer SetLivePublishRate(0)
+	pacer := publishTicker(1)
+	defer pacer.Stop()
+	if ticks := countTicks(pacer, time.Second); ticks < 15 || ticks > 25 {
+		t.Errorf("%d ticks at a live rate of 20, the live rate must win over the publisher rate", ticks)
+	}
+	SetLivePublishRate(100)
+	time.Sleep(2 * liveControlInterval)
+	if ticks := countTicks(pacer, time.Second); ticks < 80 || ticks > 120 {
+		t.Errorf("%d ticks after changing the live rate to 100", ticks)
+	}
+
+	SetLivePublishRate(0)
+	fixed := publishTicker(50)
+	defer fixed.Stop()
+	if ticks := countTicks(fixed, time.Second); ticks < 40 || ticks > 60 {
+		t.Errorf("%d ticks without a live rate, want the publisher rate of 50", ticks)
+	}
+}
+
+func TestInMemoryLogicLiveSubscribers(t *testing.T) {
+	SetLivePublishRate(100)
+	defer SetLivePublishRate(0)
+	SetLiveSubscribersPerChannel(1)
+	defer SetLiveSubscribersPerChannel(0)
+	ResetMetrics()
+	defer ResetMetrics()
+	stopChan := make(chan struct{})
+	wg := sync.WaitGroup{}
+	InMemoryLogic(0, stopChan, &wg, 2, 1, 1, "inmemory-live-", 64, 1)
+	rate := func() float64 {
+		before := atomic.LoadUint64(&TotalMessages)
+		time.Sleep(time.Second)
+		return float64(atomic.LoadUint64(&TotalMessages) - before)
+	}
+	waitFor(t, "the first messages", func() bool { return atomic.LoadUint64(&TotalMessages) > 0 })
+	if got := rate(); got < 150 || got > 250 {
+		t.Errorf("message rate %f with 1 subscriber per channel, want about 200", got)
+	}
+	SetLiveSubscribersPerChannel(3)
+	time.Sleep(2 * liveControlInterval)
+	if got := rate(); got < 450 || got > 750 {
+		t.Errorf("message rate %f with 3 subscribers per channel, want about 600", got)
+	}
+	SetLiveSubscribersPerChannel(2)
+	time.Sleep(2 * liveControlInterval)
+	if got := rate(); got < 300 || got > 500 {
+		t.Errorf("message rate %f with 2 subscribers per channel, want about 400", got)
+	}
+	close(stopChan)
+	wg.Wait()
+}
+
+func TestLiveDataSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		live     int
+		dataSize int
+		want     int
+	}{
+		{"without a live size", 0, 128, 128},
+		{"larger live size", 4096, 128, 4096},
+		{"smaller live size", 64, 1024, 64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetLiveDataSize(tt.live)
+			defer SetLiveDataSize(0)
+			if got := len(newPayload(tt.dataSize)); got != tt.want {
+				t.Errorf("newPayload(%d) is %d bytes, want %d", tt.dataSize, got, tt.want)
+			}
+			if got := len(newSequencedPayload(tt.dataSize, 1)); got != tt.want {
+				t.Errorf("newSequencedPayload(%d) is %d bytes, want %d", tt.dataSize, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package subscribe
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalClusterOptions describes the redis cluster started by the cluster command and --local-cluster.
+type LocalClusterOptions struct {
+	ServerBinary       string
+	Host               string
+	BasePort           int
+	Primaries          int
+	ReplicasPerPrimary int
+	// CPUStart pins each node to its own core, starting with this one. Negative to disable the pinning.
+	CPUStart int
+	// Dir holds the node configs, data and logs. A temporary one is created and removed if empty.
+	Dir        string
+	ServerArgs []string
+}
+
+// LocalCluster is a set of redis-server processes started and joined into a cluster by the tool.
+type LocalCluster struct {
+	options  LocalClusterOptions
+	dir      string
+	tempDir  bool
+	nodes    []string
+	commands []*exec.Cmd
+	// exited are closed once the processes are reaped
+	exited []chan struct{}
+}
+
+// StartLocalCluster starts the redis-server processes, assigns the slots, joins the nodes, sets up the
+// replicas and waits for cluster_state:ok. The processes are stopped if any step fails.
+func StartLocalCluster(options LocalClusterOptions) (cluster *LocalCluster, err error) {
+	if options.Primaries < 1 || options.ReplicasPerPrimary < 0 {
+		return nil, fmt.Errorf("a local cluster needs at least 1 primary and 0 or more replicas per primary")
+	}
+	if options.Primaries > 16384 {
+		return nil, fmt.Errorf("a local cluster can not have more primaries than slots")
+	}
+	binary, err := exec.LookPath(options.ServerBinary)
+	if err != nil {
+		return nil, fmt.Errorf("redis-server binary not found: %v", err)
+	}
+	cluster = &LocalCluster{options: options, dir: options.Dir}
+	if cluster.dir == "" {
+		if cluster.dir, err = ioutil.TempDir("", "pubsub-bench-cluster-"); err != nil {
+			return nil, err
+		}
+		cluster.tempDir = true
+	} else if err = os.MkdirAll(cluster.dir, 0755); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			// keep the logs of the nodes that failed
+			cluster.tempDir = false
+			cluster.Stop()
+			cluster = nil
+		}
+	}()
+
+	total := options.Primaries * (1 + options.ReplicasPerPrimary)
+	for pos := 0; pos < total; pos++ {
+		port := options.BasePort + pos
+		addr := net.JoinHostPort(options.Host, strconv.Itoa(port))
+		args := []string{
+			"--port", strconv.Itoa(port),
+			"--bind", options.Host,
+			"--protected-mode", "no",
+			"--cluster-enabled", "yes",
+			"--cluster-config-file", fmt.Sprintf("nodes-%d.conf", port),
+			"--cluster-node-timeout", "2000",
+			"--dbfilename", fmt.Sprintf("dump-%d.rdb", port),
+			"--appendonly", "no",
+			"--logfile", fmt.Sprintf("%d.log", port),
+			"--daemonize", "no",
+		}
+		command := exec.Command(binary, append(args, options.ServerArgs...)...)
+		command.Dir = cluster.dir
+		cpu := -1
+		if options.CPUStart >= 0 {
+			cpu = options.CPUStart + pos
+		}
+		if err = startServerProcess(command, cpu); err != nil {
+			return cluster, fmt.Errorf("unable to start the redis-server of %s: %v", addr, err)
+		}
+		exited := make(chan struct{})
+		go func() {
+			command.Wait()
+			close(exited)
+		}()
+		cluster.commands = append(cluster.commands, command)
+		cluster.exited = append(cluster.exited, exited)
+		cluster.nodes = append(cluster.nodes, addr)
+	}
+	for pos, addr := range cluster.nodes {
+		if err = waitForNode(addr, cluster.exited[pos], filepath.Join(cluster.dir, fmt.Sprintf("%d.log", options.BasePort+pos))); err != nil {
+			return
+		}
+	}
+	if err = cluster.create(); err != nil {
+		return
+	}
+	log.Printf("Local cluster ready: %d primaries, %d replicas per primary, in %s", options.Primaries, options.ReplicasPerPrimary, cluster.dir)
+	return cluster, nil
+}
+
+// waitForNode waits until the node replies to PING, failing early if its process exited.
+func waitForNode(addr string, exited chan struct{}, logfile string) error {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case <-exited:
+			return fmt.Errorf("the redis-server of %s exited, see %s", addr, logfile)
+		default:
+		}
+		if _, err := clusterDo(addr, "PING"); err == nil {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("the redis-server of %s did not start within 10 seconds, see %s", addr, logfile)
+}
+
+// dialLocalNode connects to a node of the local cluster. The nodes are started without TLS nor authentication,
+// so the --user, --password and TLS options meant for the benchmarked deployment are not used.
+func dialLocalNode(addr string) (*respConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &respConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, nil
+}
+
+// clusterDo runs a command on a node of the local cluster.
+func clusterDo(addr string, args ...string) (interface{}, error) {
+	conn, err := dialLocalNode(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	reply, err := conn.Do(args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s failed: %v", addr, strings.Join(args, " "), err)
+	}
+	return reply, nil
+}
+
+// create does what redis-cli --cluster create does: assign the slots, set distinct config epochs, meet the nodes and set up the replicas.
+func (c *LocalCluster) create() error {
+	primaries := c.options.Primaries
+	for pos := 0; pos < primaries; pos++ {
+		first, last := pos*16384/primaries, (pos+1)*16384/primaries-1
+		args := []string{"CLUSTER", "ADDSLOTS"}
+		for slot := first; slot <= last; slot++ {
+			args = append(args, strconv.Itoa(slot))
+		}
+		if _, err := clusterDo(c.nodes[pos], args...); err != nil {
+			return err
+		}
+	}
+	ids := []string{}
+	for pos, addr := range c.nodes {
+		if _, err := clusterDo(addr, "CLUSTER", "SET-CONFIG-EPOCH", strconv.Itoa(pos+1)); err != nil {
+			return err
+		}
+		reply, err := clusterDo(addr, "CLUSTER", "MYID")
+		if err != nil {
+			return err
+		}
+		id, _ := reply.(string)
+		ids = append(ids, id)
+	}
+	for _, addr := range c.nodes[1:] {
+		host, port, _ := net.SplitHostPort(addr)
+		if _, err := clusterDo(c.nodes[0], "CLUSTER", "MEET", host, port); err != nil {
+			return err
+		}
+	}
+	if err := c.waitFor("every node to know the others", func(addr string) (bool, error) {
+		reply, err := clusterDo(addr, "CLUSTER", "NODES")
+		nodes, _ := reply.(string)
+		return err == nil && len(strings.Split(strings.TrimSpace(nodes), "\n")) == len(c.nodes), err
+	}); err != nil {
+		return err
+	}
+	for pos := primaries; pos < len(c.nodes); pos++ {
+		if _, err := clusterDo(c.nodes[pos], "CLUSTER", "REPLICATE", ids[pos%primaries]); err != nil {
+			return err
+		}
+	}
+	return c.waitFor("cluster_state:ok", func(addr string) (bool, error) {
+		reply, err := clusterDo(addr, "CLUSTER", "INFO")
+		info, _ := reply.(string)
+		return err == nil && strings.Contains(info, "cluster_state:ok"), err
+	})
+}
+
+// waitFor waits up to 30 seconds for the condition to hold on every node.
+func (c *LocalCluster) waitFor(what string, condition func(addr string) (bool, error)) error {
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		ready := true
+		var err error
+		for _, addr := range c.nodes {
+			var ok bool
+			if ok, err = condition(addr); !ok {
+				ready = false
+				break
+			}
+		}
+		if ready {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s: %v", what, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Addresses returns the host:port of every node, primaries first.
+func (c *LocalCluster) Addresses() []string {
+	return append([]string{}, c.nodes...)
+}
+
+// Stop shuts every node down and removes the temporary directory.
+func (c *LocalCluster) Stop() {
+	for pos, command := range c.commands {
+		// the node closes the connection once it is down
+		if conn, err := dialLocalNode(c.nodes[pos]); err == nil {
+			conn.Send("SHUTDOWN", "NOSAVE")
+			conn.ReadReply()
+			conn.Close()
+		} else {
+			command.Process.Kill()
+		}
+		select {
+		case <-c.exited[pos]:
+		case <-time.After(5 * time.Second):
+			command.Process.Kill()
+			<-c.exited[pos]
+		}
+	}
+	c.commands = nil
+	if c.tempDir {
+		os.RemoveAll(c.dir)
+	}
+	log.Printf("Local cluster stopped")
+}
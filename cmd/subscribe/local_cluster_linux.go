@@ -0,0 +1,31 @@
+package subscribe
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// startServerProcess starts a local cluster node, pinned to cpu unless negative. The node is killed if the tool dies.
+func startServerProcess(command *exec.Cmd, cpu int) error {
+	command.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+	if cpu < 0 {
+		return command.Start()
+	}
+	// the child inherits the affinity of the thread forking it
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var previous, pinned unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &previous); err != nil {
+		return err
+	}
+	pinned.Set(cpu)
+	if err := unix.SchedSetaffinity(0, &pinned); err != nil {
+		return fmt.Errorf("unable to pin to CPU %d: %v", cpu, err)
+	}
+	defer unix.SchedSetaffinity(0, &previous)
+	return command.Start()
+}
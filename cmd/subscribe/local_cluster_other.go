@@ -0,0 +1,16 @@
+//go:build !linux
+
+package subscribe
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// startServerProcess starts a local cluster node. CPU pinning is only supported on linux.
+func startServerProcess(command *exec.Cmd, cpu int) error {
+	if cpu >= 0 {
+		return fmt.Errorf("CPU pinning of the local cluster nodes is only supported on linux")
+	}
+	return command.Start()
+}
@@ -0,0 +1,47 @@
+package subscribe
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestClusterDoIgnoresConnectionOptions(t *testing.T) {
+	if err := SetRedisConnectionOptions(RedisConnectionOptions{Username: "bob", Password: "secret", TLS: true}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetRedisConnectionOptions(RedisConnectionOptions{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	commands := make(chan interface{}, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// a plain text node replying to the first command, which must not be an AUTH nor a TLS handshake
+		c := &respConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+		command, err := c.ReadReply()
+		if err != nil {
+			commands <- err
+			return
+		}
+		commands <- command
+		conn.Write([]byte("+PONG\r\n"))
+	}()
+	reply, err := clusterDo(listener.Addr().String(), "PING")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "PONG" {
+		t.Errorf("clusterDo reply = %v, want PONG", reply)
+	}
+	if command := <-commands; fmt.Sprint(command) != "[PING]" {
+		t.Errorf("the node received %v, want a plain PING", command)
+	}
+}
@@ -0,0 +1,230 @@
+package subscribe
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// LatencyHistogram holds the end-to-end latencies (in microseconds) recorded by every subscriber.
+var LatencyHistogram = hdrhistogram.New(1, 60*1000*1000, 3)
+var latencyMutex sync.Mutex
+
+// latencyWindow holds the latencies recorded since the last TakeLatencyWindow call.
+var latencyWindow = hdrhistogram.New(1, 60*1000*1000, 3)
+
+// RecordLatency adds a single latency sample to the LatencyHistogram.
+func RecordLatency(latency time.Duration) {
+	us := latency.Microseconds()
+	if us < 1 {
+		us = 1
+	}
+	latencyMutex.Lock()
+	LatencyHistogram.RecordValue(us)
+	latencyWindow.RecordValue(us)
+	latencyMutex.Unlock()
+}
+
+// LatencySummary returns the mean and the p50, p99 and max latency percentiles in milliseconds.
+func LatencySummary() (mean, p50, p99, max float64) {
+	latencyMutex.Lock()
+	defer latencyMutex.Unlock()
+	return summarizeLatencies(LatencyHistogram)
+}
+
+// TakeLatencyWindow returns the LatencySummary of the latencies recorded since its previous call, and starts a new window.
+func TakeLatencyWindow() (mean, p50, p99, max float64) {
+	latencyMutex.Lock()
+	defer latencyMutex.Unlock()
+	mean, p50, p99, max = summarizeLatencies(latencyWindow)
+	latencyWindow.Reset()
+	return
+}
+
+func summarizeLatencies(histogram *hdrhistogram.Histogram) (mean, p50, p99, max float64) {
+	if histogram.TotalCount() == 0 {
+		return
+	}
+	mean = histogram.Mean() / 1000.0
+	p50 = float64(histogram.ValueAtQuantile(50.0)) / 1000.0
+	p99 = float64(histogram.ValueAtQuantile(99.0)) / 1000.0
+	max = float64(histogram.Max()) / 1000.0
+	return
+}
+
+// SubscribeLatencyHistogram holds the time (in microseconds) each subscriber took to connect and get its subscription confirmed.
+var SubscribeLatencyHistogram = hdrhistogram.New(1, 60*1000*1000, 3)
+
+// RecordSubscribeLatency adds a single subscribe latency sample to the SubscribeLatencyHistogram.
+func RecordSubscribeLatency(latency time.Duration) {
+	us := latency.Microseconds()
+	if us < 1 {
+		us = 1
+	}
+	latencyMutex.Lock()
+	SubscribeLatencyHistogram.RecordValue(us)
+	latencyMutex.Unlock()
+}
+
+// SubscribeLatencySummary returns the p50 and p99 subscribe latency percentiles in milliseconds.
+func SubscribeLatencySummary() (p50, p99 float64) {
+	latencyMutex.Lock()
+	defer latencyMutex.Unlock()
+	if SubscribeLatencyHistogram.TotalCount() == 0 {
+		return
+	}
+	p50 = float64(SubscribeLatencyHistogram.ValueAtQuantile(50.0)) / 1000.0
+	p99 = float64(SubscribeLatencyHistogram.ValueAtQuantile(99.0)) / 1000.0
+	return
+}
+
+// Backlog is the number of messages waiting to be consumed (pending entries, queue depth), for the systems that expose it.
+var Backlog int64
+
+// IdleTime is the total time, in nanoseconds, consumers spent blocked waiting for a message.
+var IdleTime int64
+
+// deliveryStats holds system specific delivery counters (e.g. messages received per QoS level), reported as DeliveryStats.
+var deliveryStats = map[string]*uint64{}
+var deliveryStatsMutex sync.Mutex
+
+// CountDelivery increments the named delivery counter.
+func CountDelivery(name string) {
+	deliveryStatsMutex.Lock()
+	counter, found := deliveryStats[name]
+	if !found {
+		counter = new(uint64)
+		deliveryStats[name] = counter
+	}
+	deliveryStatsMutex.Unlock()
+	atomic.AddUint64(counter, 1)
+}
+
+// DeliveryStats returns a snapshot of the delivery counters, or nil if none was used.
+func DeliveryStats() map[string]uint64 {
+	deliveryStatsMutex.Lock()
+	defer deliveryStatsMutex.Unlock()
+	if len(deliveryStats) == 0 {
+		return nil
+	}
+	stats := make(map[string]uint64, len(deliveryStats))
+	for name, counter := range deliveryStats {
+		stats[name] = atomic.LoadUint64(counter)
+	}
+	return stats
+}
+
+// ResetMetrics clears every global counter, histogram and gauge.
+func ResetMetrics() {
+	ResetMessageMetrics()
+	atomic.StoreUint64(&TotalMessages, 0)
+	atomic.StoreUint64(&PublishedMessages, 0)
+	atomic.StoreInt64(&LostMessages, 0)
+	atomic.StoreInt64(&DuplicatedMessages, 0)
+	atomic.StoreInt64(&Backlog, 0)
+	latencyMutex.Lock()
+	SubscribeLatencyHistogram.Reset()
+	latencyMutex.Unlock()
+	deliveryStatsMutex.Lock()
+	deliveryStats = map[string]*uint64{}
+	deliveryStatsMutex.Unlock()
+	resetFailovers()
+	resetSlotMigrations()
+	resetChaosEvents()
+}
+
+// ResetMessageMetrics clears the message latencies, the per role metrics and the consumer idle time at the end of the
+// warm-up, leaving the message counters and the subscribe latencies untouched.
+func ResetMessageMetrics() {
+	atomic.StoreInt64(&IdleTime, 0)
+	latencyMutex.Lock()
+	LatencyHistogram.Reset()
+	latencyWindow.Reset()
+	latencyMutex.Unlock()
+	rolesMutex.Lock()
+	roles = map[string]*roleMetrics{}
+	rolesMutex.Unlock()
+}
+
+// roleMetrics splits the received messages and latencies per node role.
+type roleMetrics struct {
+	messages  uint64
+	histogram *hdrhistogram.Histogram
+}
+
+var roles = map[string]*roleMetrics{}
+var rolesMutex sync.Mutex
+
+// RoleSummary is the throughput and latency (in milliseconds) of the subscribers connected to nodes of a given role.
+type RoleSummary struct {
+	TotalMessages uint64  `json:"TotalMessages"`
+	MessageRate   float64 `json:"MessageRate"`
+	LatencyP50    float64 `json:"LatencyP50"`
+	LatencyP99    float64 `json:"LatencyP99"`
+}
+
+// recordRoleMessage accounts a message received by a subscriber connected to a node with the given role.
+func recordRoleMessage(role string, latency time.Duration, hasLatency bool) {
+	rolesMutex.Lock()
+	defer rolesMutex.Unlock()
+	metrics, found := roles[role]
+	if !found {
+		metrics = &roleMetrics{histogram: hdrhistogram.New(1, 60*1000*1000, 3)}
+		roles[role] = metrics
+	}
+	metrics.messages++
+	if hasLatency {
+		us := latency.Microseconds()
+		if us < 1 {
+			us = 1
+		}
+		metrics.histogram.RecordValue(us)
+	}
+}
+
+// RoleSummaries returns the per role metrics, or nil if the subscribers were not placed by role.
+func RoleSummaries(duration time.Duration) map[string]RoleSummary {
+	rolesMutex.Lock()
+	defer rolesMutex.Unlock()
+	if len(roles) == 0 {
+		return nil
+	}
+	summaries := map[string]RoleSummary{}
+	for role, metrics := range roles {
+		summaries[role] = RoleSummary{
+			TotalMessages: metrics.messages,
+			MessageRate:   float64(metrics.messages) / duration.Seconds(),
+			LatencyP50:    float64(metrics.histogram.ValueAtQuantile(50.0)) / 1000.0,
+			LatencyP99:    float64(metrics.histogram.ValueAtQuantile(99.0)) / 1000.0,
+		}
+	}
+	return summaries
+}
+
+// RunSummary is the outcome of a run measured with MeasureRun.
+type RunSummary struct {
+	MessageRate         float64 `json:"MessageRate"`
+	LatencyP50          float64 `json:"LatencyP50"`
+	LatencyP99          float64 `json:"LatencyP99"`
+	SubscribeLatencyP50 float64 `json:"SubscribeLatencyP50,omitempty"`
+	SubscribeLatencyP99 float64 `json:"SubscribeLatencyP99,omitempty"`
+}
+
+// MeasureRun starts a system, lets it run for the given duration and stops it, returning its message rate and
+// latency percentiles (in milliseconds). The global metrics are reset afterwards, so it can be called before a regular run.
+func MeasureRun(duration time.Duration, start func(stopChan chan struct{}, wg *sync.WaitGroup)) (summary RunSummary) {
+	stopChan := make(chan struct{})
+	wg := sync.WaitGroup{}
+	start(stopChan, &wg)
+	startTime := time.Now()
+	time.Sleep(duration)
+	summary.MessageRate = float64(atomic.LoadUint64(&TotalMessages)) / time.Since(startTime).Seconds()
+	_, summary.LatencyP50, summary.LatencyP99, _ = LatencySummary()
+	summary.SubscribeLatencyP50, summary.SubscribeLatencyP99 = SubscribeLatencySummary()
+	close(stopChan)
+	wg.Wait()
+	ResetMetrics()
+	return
+}
@@ -0,0 +1,192 @@
+package subscribe
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// errSlotMigrated is returned by the receivers when the server moved the slot of a sharded channel away ( SUNSUBSCRIBE or MOVED ).
+var errSlotMigrated = errors.New("the slot of the channel was migrated")
+
+// SlotMigration is a change of the node owning the slot of sharded channels, observed by their subscribers during the run.
+type SlotMigration struct {
+	// Time is the unix time, in milliseconds, the first subscriber moved to the new node
+	Time        int64  `json:"Time"`
+	Slot        int64  `json:"Slot"`
+	OldNode     string `json:"OldNode"`
+	NewNode     string `json:"NewNode"`
+	Subscribers int64  `json:"Subscribers"`
+	// DeliveryGapMax is the longest time, in milliseconds, a subscriber went from its last message on the old node to its first one on the new node
+	DeliveryGapMax float64 `json:"DeliveryGapMax"`
+}
+
+// liveSubscriber is a sharded subscriber following the slot migrations.
+type liveSubscriber struct {
+	channel string
+	role    string
+	name    string
+	addr    string
+	// interrupt closes the subscriber connection, so that it is placed again
+	interrupt func()
+}
+
+var liveTopologyEnabled bool
+var liveTopologyHost string
+var liveTopologyPort string
+var liveTopology *ClusterTopology
+var liveTopologyRefreshed time.Time
+var liveSubscribers = map[*liveSubscriber]struct{}{}
+var liveTopologyMutex sync.Mutex
+
+var slotMigrations []*SlotMigration
+var slotMigrationsMutex sync.Mutex
+
+// EnableTopologyRefresh makes the sharded subscribers follow the slot migrations, using the topology discovered from the seed nodes.
+func EnableTopologyRefresh(host string, port string) {
+	liveTopologyMutex.Lock()
+	defer liveTopologyMutex.Unlock()
+	liveTopologyEnabled, liveTopologyHost, liveTopologyPort = true, host, port
+}
+
+// DisableTopologyRefresh stops following the slot migrations and forgets the discovered topology, so that the next run starts without them.
+func DisableTopologyRefresh() {
+	liveTopologyMutex.Lock()
+	defer liveTopologyMutex.Unlock()
+	liveTopologyEnabled, liveTopologyHost, liveTopologyPort = false, "", ""
+	liveTopology, liveTopologyRefreshed = nil, time.Time{}
+	liveSubscribers = map[*liveSubscriber]struct{}{}
+}
+
+// RefreshTopology discovers the cluster topology again, at most once per second, and interrupts the sharded
+// subscribers connected to a node that no longer serves the slot of their channel.
+func RefreshTopology() {
+	liveTopologyMutex.Lock()
+	defer liveTopologyMutex.Unlock()
+	if time.Since(liveTopologyRefreshed) < time.Second {
+		return
+	}
+	topology, err := DiscoverTopologyFromArgs(liveTopologyHost, liveTopologyPort)
+	liveTopologyRefreshed = time.Now()
+	if err != nil {
+		log.Printf("Unable to refresh the cluster topology: %v", err)
+		return
+	}
+	liveTopology = topology
+	for subscriber := range liveSubscribers {
+		if target, _ := liveTarget(subscriber.channel, subscriber.role, subscriber.name, subscriber.addr); target != "" && target != subscriber.addr {
+			subscriber.interrupt()
+		}
+	}
+}
+
+// TopologyRefreshRoutine refreshes the cluster topology periodically, catching the migrations the subscribers were not told about.
+func TopologyRefreshRoutine(interval time.Duration, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			RefreshTopology()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// liveTarget returns the node a sharded subscriber should use according to the last topology: the current one while
+// it still serves the channel slot with the same role, otherwise a node of the shard now owning the slot. It must be
+// called holding liveTopologyMutex, and returns an empty address when no shard owns the slot.
+func liveTarget(channel string, role string, name string, current string) (addr string, nodeRole string) {
+	if liveTopology == nil {
+		return "", ""
+	}
+	shard := liveTopology.ShardOfSlot(keyHashSlot(channel))
+	if shard == nil {
+		return "", ""
+	}
+	candidates := []ClusterNode{shard.Primary}
+	if replicas := shard.OnlineReplicas(); role == NodeRoleReplica && len(replicas) > 0 {
+		candidates = replicas
+	}
+	for _, node := range candidates {
+		if node.Addr == current {
+			return node.Addr, node.Role
+		}
+	}
+	node := candidates[int(crc16(name))%len(candidates)]
+	return node.Addr, node.Role
+}
+
+// placeLiveSubscriber refreshes the topology and returns the node the subscriber should reconnect to.
+func placeLiveSubscriber(subscriber *liveSubscriber) (addr string, role string) {
+	RefreshTopology()
+	liveTopologyMutex.Lock()
+	defer liveTopologyMutex.Unlock()
+	return liveTarget(subscriber.channel, subscriber.role, subscriber.name, subscriber.addr)
+}
+
+func registerLiveSubscriber(channel string, role string, name string, addr string, interrupt func()) *liveSubscriber {
+	subscriber := &liveSubscriber{channel: channel, role: role, name: name, addr: addr, interrupt: interrupt}
+	liveTopologyMutex.Lock()
+	liveSubscribers[subscriber] = struct{}{}
+	liveTopologyMutex.Unlock()
+	return subscriber
+}
+
+func unregisterLiveSubscriber(subscriber *liveSubscriber) {
+	liveTopologyMutex.Lock()
+	delete(liveSubscribers, subscriber)
+	liveTopologyMutex.Unlock()
+}
+
+func moveLiveSubscriber(subscriber *liveSubscriber, addr string, role string) {
+	liveTopologyMutex.Lock()
+	subscriber.addr, subscriber.role = addr, role
+	liveTopologyMutex.Unlock()
+}
+
+// noteSlotMigration records a subscriber moved from one node to another, grouping the subscribers by slot and nodes.
+func noteSlotMigration(channel string, oldNode string, newNode string) *SlotMigration {
+	slot := keyHashSlot(channel)
+	slotMigrationsMutex.Lock()
+	defer slotMigrationsMutex.Unlock()
+	for _, migration := range slotMigrations {
+		if migration.Slot == slot && migration.OldNode == oldNode && migration.NewNode == newNode {
+			migration.Subscribers++
+			return migration
+		}
+	}
+	migration := &SlotMigration{Time: time.Now().UnixNano() / int64(time.Millisecond), Slot: slot, OldNode: oldNode, NewNode: newNode, Subscribers: 1}
+	slotMigrations = append(slotMigrations, migration)
+	log.Printf("Slot %d of channel %s migrated from %s to %s", slot, channel, oldNode, newNode)
+	return migration
+}
+
+func recordDeliveryGap(migration *SlotMigration, gap time.Duration) {
+	slotMigrationsMutex.Lock()
+	defer slotMigrationsMutex.Unlock()
+	if ms := float64(gap.Microseconds()) / 1000.0; ms > migration.DeliveryGapMax {
+		migration.DeliveryGapMax = ms
+	}
+}
+
+// SlotMigrations returns the slot migrations observed during the run.
+func SlotMigrations() (migrations []SlotMigration) {
+	slotMigrationsMutex.Lock()
+	defer slotMigrationsMutex.Unlock()
+	for _, migration := range slotMigrations {
+		migrations = append(migrations, *migration)
+	}
+	return
+}
+
+func resetSlotMigrations() {
+	slotMigrationsMutex.Lock()
+	defer slotMigrationsMutex.Unlock()
+	slotMigrations = nil
+}
@@ -0,0 +1,149 @@
+package subscribe
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	mqtt "github.com/mochi-co/mqtt/server"
+	"github.com/mochi-co/mqtt/server/listeners"
+)
+
+func newMqttClient(broker string, clientId string, cleanSession bool) paho.Client {
+	opts := paho.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientId).
+		SetCleanSession(cleanSession).
+		SetOrderMatters(false).
+		SetAutoReconnect(false).
+		SetConnectTimeout(10 * time.Second)
+	client := paho.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		log.Fatal(token.Error())
+	}
+	return client
+}
+
+func MqttSubscriberRoutine(broker string, subscriberName string, topic string, qos byte, cleanSession bool, printMessages bool, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	client := newMqttClient(broker, subscriberName, cleanSession)
+	defer client.Disconnect(250)
+	token := client.Subscribe(topic, qos, func(_ paho.Client, msg paho.Message) {
+		if printMessages {
+			fmt.Println(fmt.Sprintf("received message in topic %s (qos %d). Message: %s", msg.Topic(), msg.Qos(), string(msg.Payload())))
+		}
+		CountDelivery(fmt.Sprintf("received-qos%d", msg.Qos()))
+		if msg.Duplicate() {
+			CountDelivery("received-duplicates")
+		}
+		recordPayloadLatency(string(msg.Payload()))
+		atomic.AddUint64(&TotalMessages, 1)
+	})
+	if token.Wait() && token.Error() != nil {
+		log.Fatal(token.Error())
+	}
+	<-stop
+}
+
+func MqttPublisherRoutine(client paho.Client, topic string, qos byte, dataSize int, publishRate int, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	tick := publishTicker(publishRate)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			token := client.Publish(topic, qos, false, newPayload(dataSize))
+			go func() {
+				// for QoS 1 and 2 the token completes once the broker acknowledged the message
+				if token.Wait() && token.Error() != nil {
+					CountDelivery("publish-errors")
+					return
+				}
+				CountDelivery(fmt.Sprintf("published-qos%d", qos))
+			}()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// startEmbeddedMqttBroker runs an in-process MQTT broker listening on the --mqtt-broker address.
+func startEmbeddedMqttBroker(broker string) *mqtt.Server {
+	u, err := url.Parse(broker)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := mqtt.NewServer(nil)
+	if err = server.AddListener(listeners.NewTCP("pubsub-bench", u.Host), nil); err != nil {
+		log.Fatal(err)
+	}
+	if err = server.Serve(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Started embedded mqtt broker at %s", u.Host)
+	return server
+}
+
+func MqttLogic(debug int, stopChan chan struct{}, wg *sync.WaitGroup, broker string, embeddedBroker bool, qos int, cleanSession bool, wildcard bool, channel_maximum int, channel_minimum int, subscribers_per_channel int, subscribe_prefix string, data_size int, publish_rate int) {
+	printMessages := false
+	if debug >= 2 {
+		printMessages = true
+	}
+	if qos < 0 || qos > 2 {
+		log.Fatal(fmt.Errorf("unsupported mqtt qos %d ( choices 0,1,2 )", qos))
+	}
+	var server *mqtt.Server
+	if embeddedBroker {
+		server = startEmbeddedMqttBroker(broker)
+	}
+	// the embedded broker is only closed after every client is done, otherwise in-flight QoS 1/2 publishes never complete
+	clients := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-stopChan
+		clients.Wait()
+		if server != nil {
+			server.Close()
+		}
+	}()
+
+	startSubscribers(stopChan, &clients, channel_maximum, channel_minimum, subscribers_per_channel, func(channel_id int, channel_subscriber_number int, stop chan struct{}) {
+		filter := fmt.Sprintf("%s%d", subscribe_prefix, channel_id)
+		if wildcard {
+			// "<topic>/#" also matches the parent level, exercising the broker wildcard matching
+			filter += "/#"
+		}
+		subscriberName := fmt.Sprintf("subscriber#%d-%s%d", channel_subscriber_number, subscribe_prefix, channel_id)
+		if debug >= 1 {
+			log.Printf("Topic filter %s subcriber #%d using %s", filter, channel_subscriber_number, broker)
+		}
+		clients.Add(1)
+		go MqttSubscriberRoutine(broker, subscriberName, filter, byte(qos), cleanSession, printMessages, stop, &clients)
+	})
+
+	if publish_rate <= 0 {
+		return
+	}
+	client := newMqttClient(broker, "pubsub-bench-publisher", true)
+	publishers := sync.WaitGroup{}
+	for channel_id := channel_minimum; channel_id <= channel_maximum; channel_id++ {
+		topic := fmt.Sprintf("%s%d", subscribe_prefix, channel_id)
+		publishers.Add(1)
+		go MqttPublisherRoutine(client, topic, byte(qos), data_size, publish_rate, stopChan, &publishers)
+	}
+	clients.Add(1)
+	go func() {
+		defer clients.Done()
+		publishers.Wait()
+		client.Disconnect(250)
+	}()
+}
@@ -0,0 +1,50 @@
+package subscribe
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+)
+
+// freeAddress returns a local address nothing listens on.
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestMqttLogicEmbeddedBroker(t *testing.T) {
+	tests := []struct {
+		name     string
+		qos      int
+		wildcard bool
+	}{
+		{"qos0", 0, false},
+		{"qos1", 1, false},
+		{"qos2", 2, false},
+		{"qos1 wildcard", 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := "tcp://" + freeAddress(t)
+			var stats map[string]uint64
+			runUntilMessages(t, 20, func(stopChan chan struct{}, wg *sync.WaitGroup) {
+				MqttLogic(0, stopChan, wg, broker, true, tt.qos, true, tt.wildcard, 2, 1, 2, "mqtt-test-", 128, 50)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-stopChan
+					stats = DeliveryStats()
+				}()
+			})
+			if received := fmt.Sprintf("received-qos%d", tt.qos); stats[received] == 0 {
+				t.Errorf("no %s delivery stats, got %v", received, stats)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package subscribe
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+func NatsSubscriberRoutine(url string, subscriberName string, subject string, queueGroup string, printMessages bool, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	conn, err := nats.Connect(url, nats.Name(subscriberName))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	handler := func(msg *nats.Msg) {
+		if printMessages {
+			fmt.Println(fmt.Sprintf("received message in subject %s. Message: %s", msg.Subject, string(msg.Data)))
+		}
+		recordPayloadLatency(string(msg.Data))
+		atomic.AddUint64(&TotalMessages, 1)
+	}
+	if queueGroup != "" {
+		_, err = conn.QueueSubscribe(subject, queueGroup, handler)
+	} else {
+		_, err = conn.Subscribe(subject, handler)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	<-stop
+}
+
+func NatsPublisherRoutine(conn *nats.Conn, subject string, dataSize int, publishRate int, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	tick := publishTicker(publishRate)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if err := conn.Publish(subject, []byte(newPayload(dataSize))); err != nil {
+				log.Printf("Error while publishing to subject %s: %v", subject, err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// startEmbeddedNatsServer runs an in-process NATS server, so that no external service is needed.
+func startEmbeddedNatsServer(debug int) *server.Server {
+	ns, err := server.NewServer(&server.Options{
+		Host:   "127.0.0.1",
+		Port:   server.RANDOM_PORT,
+		NoSigs: true,
+		NoLog:  debug < 1,
+		Debug:  debug >= 2,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if debug >= 1 {
+		ns.ConfigureLogger()
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(10 * time.Second) {
+		log.Fatal(fmt.Errorf("embedded nats server was not ready for connections after 10 seconds"))
+	}
+	log.Printf("Started embedded nats server at %s", ns.ClientURL())
+	return ns
+}
+
+func NatsLogic(debug int, stopChan chan struct{}, wg *sync.WaitGroup, url string, embeddedServer bool, queueGroup string, channel_maximum int, channel_minimum int, subscribers_per_channel int, subscribe_prefix string, data_size int, publish_rate int) {
+	printMessages := false
+	if debug >= 2 {
+		printMessages = true
+	}
+	var ns *server.Server
+	if embeddedServer {
+		ns = startEmbeddedNatsServer(debug)
+		url = ns.ClientURL()
+	}
+	// the embedded server is only shut down after every client is closed, otherwise they keep reconnecting to it
+	clients := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-stopChan
+		clients.Wait()
+		if ns != nil {
+			ns.Shutdown()
+		}
+	}()
+
+	startSubscribers(stopChan, &clients, channel_maximum, channel_minimum, subscribers_per_channel, func(channel_id int, channel_subscriber_number int, stop chan struct{}) {
+		subject := fmt.Sprintf("%s%d", subscribe_prefix, channel_id)
+		subscriberName := fmt.Sprintf("subscriber#%d-%s%d", channel_subscriber_number, subscribe_prefix, channel_id)
+		if debug >= 1 {
+			log.Printf("Subject %s subcriber #%d using %s", subject, channel_subscriber_number, url)
+		}
+		clients.Add(1)
+		go NatsSubscriberRoutine(url, subscriberName, subject, queueGroup, printMessages, stop, &clients)
+	})
+
+	if publish_rate <= 0 {
+		return
+	}
+	conn, err := nats.Connect(url, nats.Name("pubsub-bench-publisher"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	publishers := sync.WaitGroup{}
+	for channel_id := channel_minimum; channel_id <= channel_maximum; channel_id++ {
+		subject := fmt.Sprintf("%s%d", subscribe_prefix, channel_id)
+		publishers.Add(1)
+		go NatsPublisherRoutine(conn, subject, data_size, publish_rate, stopChan, &publishers)
+	}
+	clients.Add(1)
+	go func() {
+		defer clients.Done()
+		publishers.Wait()
+		conn.Close()
+	}()
+}
@@ -0,0 +1,76 @@
+package subscribe
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestNatsLogicEmbeddedServer(t *testing.T) {
+	// the repeated runs of find-max, sweep and repetitions must not leave connections behind
+	goroutines := 0
+	for run := 1; run <= 3; run++ {
+		runUntilMessages(t, 20, func(stopChan chan struct{}, wg *sync.WaitGroup) {
+			NatsLogic(0, stopChan, wg, "", true, "", 2, 1, 2, "nats-test-", 128, 50)
+		})
+		if run == 1 {
+			time.Sleep(100 * time.Millisecond)
+			goroutines = runtime.NumGoroutine()
+			continue
+		}
+		waitFor(t, "the clients of the run to be closed", func() bool { return runtime.NumGoroutine() <= goroutines })
+	}
+}
+
+func TestNatsSubscribers(t *testing.T) {
+	tests := []struct {
+		name       string
+		queueGroup string
+		// interest is the number of matching subscriptions, each queue group counting once
+		interest int
+		want     uint64
+	}{
+		{"fan-out", "", 2, 200},
+		{"queue group", "workers", 1, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ResetMetrics()
+			defer ResetMetrics()
+			ns := startEmbeddedNatsServer(0)
+			defer ns.Shutdown()
+			stopChan := make(chan struct{})
+			wg := sync.WaitGroup{}
+			for subscriber := 0; subscriber < 2; subscriber++ {
+				wg.Add(1)
+				go NatsSubscriberRoutine(ns.ClientURL(), "subscriber", "subject", tt.queueGroup, false, stopChan, &wg)
+			}
+			waitFor(t, "the subscriptions", func() bool {
+				return ns.NumClients() == 2 && ns.GlobalAccount().Interest("subject") == tt.interest
+			})
+			conn, err := nats.Connect(ns.ClientURL())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			for i := 0; i < 100; i++ {
+				if err := conn.Publish("subject", []byte(newPayload(16))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			conn.Flush()
+			waitFor(t, "the messages", func() bool { return atomic.LoadUint64(&TotalMessages) >= tt.want })
+			// no message is delivered twice to a queue group
+			time.Sleep(100 * time.Millisecond)
+			close(stopChan)
+			wg.Wait()
+			if got := atomic.LoadUint64(&TotalMessages); got != tt.want {
+				t.Errorf("received %d messages, want %d", got, tt.want)
+			}
+		})
+	}
+}
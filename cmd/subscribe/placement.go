@@ -0,0 +1,120 @@
+package subscribe
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+var SubscribeOnPrimary string = "primary"
+var SubscribeOnReplica string = "replica"
+var SubscribeOnAny string = "any"
+var SubscribeOnChoices []string = []string{SubscribeOnPrimary, SubscribeOnReplica, SubscribeOnAny}
+
+// subscriberPlacement decides which node each (sharded) pub/sub subscriber connects to.
+type subscriberPlacement struct {
+	nodes       []string
+	counts      []int
+	topology    *ClusterTopology
+	subscribeOn string
+	// next is the round-robin position among the candidate nodes of each shard, by primary address
+	next map[string]int
+}
+
+func newSubscriberPlacement(distributeSubscribers bool, host string, port string, subscribeOn string) *subscriberPlacement {
+	if subscribeOn != SubscribeOnPrimary && !distributeSubscribers {
+		log.Fatal(fmt.Errorf("--subscribe-on %s requires the cluster topology ( --oss-cluster-api-distribute-subscribers )", subscribeOn))
+	}
+	if !distributeSubscribers {
+		nodes, counts := getNodesInfo(distributeSubscribers, host, port)
+		return &subscriberPlacement{nodes: nodes, counts: counts, subscribeOn: subscribeOn}
+	}
+	topology, err := DiscoverTopologyFromArgs(host, port)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p := &subscriberPlacement{topology: topology, subscribeOn: subscribeOn, next: map[string]int{}}
+	for _, node := range topology.Nodes() {
+		p.nodes = append(p.nodes, node.Addr)
+		p.counts = append(p.counts, 0)
+	}
+	log.Printf("Using the following nodes (total=%d, subscribe-on=%s) to connect %v", len(p.nodes), subscribeOn, p.nodes)
+	return p
+}
+
+// primaries returns the addresses used to bootstrap cluster aware clients, such as the built-in publishers.
+func (p *subscriberPlacement) primaries() []string {
+	if p.topology == nil {
+		return p.nodes
+	}
+	return p.topology.Primaries()
+}
+
+// pick returns the address and role of the node the subscriber connects to.
+// Sharded channels are placed on the shard owning the channel slot, the others are spread among the shards.
+func (p *subscriberPlacement) pick(channel string, channel_id int, sharded bool) (addr string, role string) {
+	if p.topology == nil {
+		addr = p.nodes[channel_id%len(p.nodes)]
+	} else {
+		shard := &p.topology.Shards[channel_id%len(p.topology.Shards)]
+		if sharded {
+			shard = p.topology.ShardOfSlot(keyHashSlot(channel))
+			if shard == nil {
+				log.Fatal(fmt.Errorf("no shard owns the slot of channel %s", channel))
+			}
+		}
+		candidates := []ClusterNode{shard.Primary}
+		switch p.subscribeOn {
+		case SubscribeOnReplica:
+			replicas := shard.OnlineReplicas()
+			if len(replicas) == 0 {
+				log.Fatal(fmt.Errorf("shard of primary %s has no online replicas to subscribe on", shard.Primary.Addr))
+			}
+			candidates = replicas
+		case SubscribeOnAny:
+			candidates = append(candidates, shard.OnlineReplicas()...)
+		}
+		// round-robin among the shard nodes, whatever the number of subscribers per channel
+		node := candidates[p.next[shard.Primary.Addr]%len(candidates)]
+		p.next[shard.Primary.Addr]++
+		addr, role = node.Addr, node.Role
+	}
+	for pos, nodeAddr := range p.nodes {
+		if nodeAddr == addr {
+			p.counts[pos]++
+		}
+	}
+	return
+}
+
+func (p *subscriberPlacement) logCounts() {
+	for nodes_pos, count := range p.counts {
+		log.Printf("Node %s total subscriptions=%d", p.nodes[nodes_pos], count)
+	}
+}
+
+// keyHashSlot returns the cluster slot of a key or channel, honoring {hash tags}.
+func keyHashSlot(key string) int64 {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int64(crc16(key) & 16383)
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) used by redis cluster.
+func crc16(key string) uint16 {
+	crc := uint16(0)
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
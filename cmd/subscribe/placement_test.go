@@ -0,0 +1,135 @@
+package subscribe
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestKeyHashSlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		want int64
+	}{
+		{"", 0},
+		{"123456789", 12739},
+		{"foo", 12182},
+		{"bar", 5061},
+		{"hello", 866},
+		{"{foo}.channel", 12182},
+		{"prefix{bar}suffix", 5061},
+		{"foo{bar}{zap}", 5061},
+		// an empty hash tag hashes the whole key
+		{"foo{}{bar}", keyHashSlot("foo{}{bar}")},
+		{"{}", 15257},
+		{"foo{{bar}}zap", keyHashSlot("{bar")},
+		// an unterminated tag hashes the whole key
+		{"{foo", keyHashSlot("{foo")},
+	}
+	for _, tt := range tests {
+		if got := keyHashSlot(tt.key); got != tt.want {
+			t.Errorf("keyHashSlot(%q) = %d, want %d", tt.key, got, tt.want)
+		}
+	}
+	if keyHashSlot("foo{}{bar}") == keyHashSlot("bar") {
+		t.Errorf("an empty hash tag must not select the next tag")
+	}
+}
+
+func TestSubscriberPlacementPick(t *testing.T) {
+	topology := &ClusterTopology{Shards: []ClusterShard{
+		{
+			Slots:    [][2]int64{{0, 8191}},
+			Primary:  ClusterNode{Addr: "p1:1", Role: NodeRolePrimary},
+			Replicas: []ClusterNode{{Addr: "r1a:1", Role: NodeRoleReplica}, {Addr: "r1b:1", Role: NodeRoleReplica}},
+		},
+		{
+			Slots:    [][2]int64{{8192, 16383}},
+			Primary:  ClusterNode{Addr: "p2:1", Role: NodeRolePrimary},
+			Replicas: []ClusterNode{{Addr: "r2a:1", Role: NodeRoleReplica}, {Addr: "r2b:1", Role: NodeRoleReplica}},
+		},
+	}}
+	tests := []struct {
+		name              string
+		subscribeOn       string
+		sharded           bool
+		channels          int
+		subscribers       int
+		wantPerNode       map[string]int
+		wantRole          string
+		wantShardOfSlotOk bool
+	}{
+		{"primary", SubscribeOnPrimary, false, 4, 1, map[string]int{"p1:1": 2, "p2:1": 2}, NodeRolePrimary, false},
+		// single subscriber channels must not all land on the first replica
+		{"replica single subscriber", SubscribeOnReplica, false, 4, 1, map[string]int{"r1a:1": 1, "r1b:1": 1, "r2a:1": 1, "r2b:1": 1}, NodeRoleReplica, false},
+		{"replica two subscribers", SubscribeOnReplica, false, 2, 2, map[string]int{"r1a:1": 1, "r1b:1": 1, "r2a:1": 1, "r2b:1": 1}, NodeRoleReplica, false},
+		{"any", SubscribeOnAny, false, 6, 1, map[string]int{"p1:1": 1, "r1a:1": 1, "r1b:1": 1, "p2:1": 1, "r2a:1": 1, "r2b:1": 1}, "", false},
+		{"sharded", SubscribeOnPrimary, true, 8, 1, nil, NodeRolePrimary, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &subscriberPlacement{topology: topology, subscribeOn: tt.subscribeOn, next: map[string]int{}}
+			for _, node := range topology.Nodes() {
+				p.nodes = append(p.nodes, node.Addr)
+				p.counts = append(p.counts, 0)
+			}
+			perNode := map[string]int{}
+			for channel_id := 1; channel_id <= tt.channels; channel_id++ {
+				channel := fmt.Sprintf("channel-%d", channel_id)
+				for subscriber_number := 1; subscriber_number <= tt.subscribers; subscriber_number++ {
+					addr, role := p.pick(channel, channel_id, tt.sharded)
+					if tt.wantRole != "" && role != tt.wantRole {
+						t.Errorf("pick(%s, #%d) role = %s, want %s", channel, subscriber_number, role, tt.wantRole)
+					}
+					if tt.wantShardOfSlotOk && topology.ShardOfSlot(keyHashSlot(channel)).Primary.Addr != addr {
+						t.Errorf("sharded channel %s placed on %s, not on the shard owning its slot", channel, addr)
+					}
+					perNode[addr]++
+				}
+			}
+			if tt.wantPerNode != nil {
+				if fmt.Sprint(perNode) != fmt.Sprint(tt.wantPerNode) {
+					t.Errorf("subscribers per node = %v, want %v", perNode, tt.wantPerNode)
+				}
+			}
+			for pos, addr := range p.nodes {
+				if p.counts[pos] != perNode[addr] {
+					t.Errorf("count of %s = %d, want %d", addr, p.counts[pos], perNode[addr])
+				}
+			}
+		})
+	}
+}
+
+func TestSubscriberPlacementPickSkipsUnhealthyReplicas(t *testing.T) {
+	topology := &ClusterTopology{Shards: []ClusterShard{
+		{
+			Slots:   [][2]int64{{0, 16383}},
+			Primary: ClusterNode{Addr: "p1:1", Role: NodeRolePrimary, Health: "online"},
+			Replicas: []ClusterNode{
+				{Addr: "r1a:1", Role: NodeRoleReplica, Health: "online"},
+				{Addr: "r1b:1", Role: NodeRoleReplica, Health: "fail"},
+				{Addr: "r1c:1", Role: NodeRoleReplica, Health: "loading"},
+			},
+		},
+	}}
+	tests := []struct {
+		subscribeOn string
+		wantPerNode map[string]int
+	}{
+		{SubscribeOnReplica, map[string]int{"r1a:1": 4}},
+		{SubscribeOnAny, map[string]int{"p1:1": 2, "r1a:1": 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subscribeOn, func(t *testing.T) {
+			p := &subscriberPlacement{topology: topology, subscribeOn: tt.subscribeOn, next: map[string]int{}}
+			perNode := map[string]int{}
+			for channel_id := 1; channel_id <= 4; channel_id++ {
+				addr, _ := p.pick(fmt.Sprintf("channel-%d", channel_id), channel_id, false)
+				perNode[addr]++
+			}
+			if fmt.Sprint(perNode) != fmt.Sprint(tt.wantPerNode) {
+				t.Errorf("subscribers per node = %v, want %v", perNode, tt.wantPerNode)
+			}
+		})
+	}
+}
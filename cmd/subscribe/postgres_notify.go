@@ -0,0 +1,114 @@
+package subscribe
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PostgresMaxPayload is the maximum NOTIFY payload size accepted by PostgreSQL in the default configuration.
+const PostgresMaxPayload = 7999
+
+func PostgresListenerRoutine(url string, subscriberName string, channel string, printMessages bool, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config, err := pgx.ParseConfig(url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.RuntimeParams["application_name"] = subscriberName
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close(context.Background())
+	if _, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error while waiting for notifications on channel %s: %v", channel, err)
+			}
+			return
+		}
+		if printMessages {
+			fmt.Println(fmt.Sprintf("received notification in channel %s. Message: %s", notification.Channel, notification.Payload))
+		}
+		recordPayloadLatency(notification.Payload)
+		atomic.AddUint64(&TotalMessages, 1)
+	}
+}
+
+func PostgresNotifierRoutine(pool *pgxpool.Pool, channel string, dataSize int, publishRate int, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	ctx := context.Background()
+	tick := publishTicker(publishRate)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if _, err := pool.Exec(ctx, "SELECT pg_notify($1, $2)", channel, newPayload(dataSize)); err != nil {
+				log.Printf("Error while notifying channel %s: %v", channel, err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func PostgresNotifyLogic(debug int, stopChan chan struct{}, wg *sync.WaitGroup, url string, channel_maximum int, channel_minimum int, subscribers_per_channel int, subscribe_prefix string, data_size int, publish_rate int) {
+	printMessages := false
+	if debug >= 2 {
+		printMessages = true
+	}
+	if data_size > PostgresMaxPayload {
+		log.Fatal(fmt.Errorf("--data-size %d exceeds the maximum NOTIFY payload size of %d bytes", data_size, PostgresMaxPayload))
+	}
+
+	startSubscribers(stopChan, wg, channel_maximum, channel_minimum, subscribers_per_channel, func(channel_id int, channel_subscriber_number int, stop chan struct{}) {
+		channel := fmt.Sprintf("%s%d", subscribe_prefix, channel_id)
+		subscriberName := fmt.Sprintf("subscriber#%d-%s%d", channel_subscriber_number, subscribe_prefix, channel_id)
+		if debug >= 1 {
+			log.Printf("Channel %s listener #%d", channel, channel_subscriber_number)
+		}
+		wg.Add(1)
+		go PostgresListenerRoutine(url, subscriberName, channel, printMessages, stop, wg)
+	})
+
+	if publish_rate <= 0 {
+		return
+	}
+	pool, err := pgxpool.Connect(context.Background(), url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	publishers := sync.WaitGroup{}
+	for channel_id := channel_minimum; channel_id <= channel_maximum; channel_id++ {
+		channel := fmt.Sprintf("%s%d", subscribe_prefix, channel_id)
+		publishers.Add(1)
+		go PostgresNotifierRoutine(pool, channel, data_size, publish_rate, stopChan, &publishers)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		publishers.Wait()
+		pool.Close()
+	}()
+}
@@ -0,0 +1,129 @@
+package subscribe
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgproto3/v2"
+)
+
+// fakePostgres is a wire-compatible stand-in of PostgreSQL supporting LISTEN ( simple protocol ) and
+// SELECT pg_notify($1, $2) ( extended protocol ), the only commands of the postgres-notify system.
+type fakePostgres struct {
+	listener net.Listener
+	mu       sync.Mutex
+	// listeners holds the connections listening on each channel
+	listeners map[string][]*fakePostgresConn
+}
+
+type fakePostgresConn struct {
+	conn net.Conn
+	mu   sync.Mutex
+	pid  uint32
+}
+
+func (c *fakePostgresConn) send(messages ...pgproto3.BackendMessage) error {
+	buf := []byte{}
+	for _, message := range messages {
+		buf = message.Encode(buf)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func startFakePostgres(t *testing.T) *fakePostgres {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakePostgres{listener: listener, listeners: map[string][]*fakePostgresConn{}}
+	go func() {
+		for pid := uint32(1); ; pid++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(&fakePostgresConn{conn: conn, pid: pid})
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+func (s *fakePostgres) url() string {
+	return "postgres://postgres@" + s.listener.Addr().String() + "/postgres?sslmode=disable"
+}
+
+func (s *fakePostgres) serve(c *fakePostgresConn) {
+	defer c.conn.Close()
+	backend := pgproto3.NewBackend(pgproto3.NewChunkReader(c.conn), c.conn)
+	startup, err := backend.ReceiveStartupMessage()
+	if _, ok := startup.(*pgproto3.StartupMessage); err != nil || !ok {
+		return
+	}
+	c.send(&pgproto3.AuthenticationOk{},
+		&pgproto3.ParameterStatus{Name: "server_version", Value: "14.0"},
+		&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"},
+		&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"},
+		&pgproto3.BackendKeyData{ProcessID: c.pid},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	var parameters [][]byte
+	for {
+		message, err := backend.Receive()
+		if err != nil {
+			return
+		}
+		switch message := message.(type) {
+		case *pgproto3.Query:
+			if channel := strings.TrimPrefix(message.String, "LISTEN "); channel != message.String {
+				s.mu.Lock()
+				channel = strings.Trim(channel, `"`)
+				s.listeners[channel] = append(s.listeners[channel], c)
+				s.mu.Unlock()
+				c.send(&pgproto3.CommandComplete{CommandTag: []byte("LISTEN")}, &pgproto3.ReadyForQuery{TxStatus: 'I'})
+			} else {
+				c.send(&pgproto3.EmptyQueryResponse{}, &pgproto3.ReadyForQuery{TxStatus: 'I'})
+			}
+		case *pgproto3.Parse:
+			c.send(&pgproto3.ParseComplete{})
+		case *pgproto3.Describe:
+			if message.ObjectType == 'S' {
+				c.send(&pgproto3.ParameterDescription{ParameterOIDs: []uint32{25, 25}})
+			}
+			c.send(&pgproto3.NoData{})
+		case *pgproto3.Bind:
+			parameters = message.Parameters
+			c.send(&pgproto3.BindComplete{})
+		case *pgproto3.Execute:
+			if len(parameters) == 2 {
+				s.notify(string(parameters[0]), string(parameters[1]), c.pid)
+			}
+			c.send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")})
+		case *pgproto3.Sync:
+			c.send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		case *pgproto3.Terminate:
+			return
+		}
+	}
+}
+
+func (s *fakePostgres) notify(channel string, payload string, pid uint32) {
+	s.mu.Lock()
+	listeners := append([]*fakePostgresConn{}, s.listeners[channel]...)
+	s.mu.Unlock()
+	for _, listener := range listeners {
+		listener.send(&pgproto3.NotificationResponse{PID: pid, Channel: channel, Payload: payload})
+	}
+}
+
+func TestPostgresNotifyLogic(t *testing.T) {
+	server := startFakePostgres(t)
+	runUntilMessages(t, 20, func(stopChan chan struct{}, wg *sync.WaitGroup) {
+		PostgresNotifyLogic(0, stopChan, wg, server.url(), 2, 1, 2, "postgres-test-", 128, 50)
+	})
+}
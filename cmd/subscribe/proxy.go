@@ -0,0 +1,202 @@
+package subscribe
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// FaultProfile describes the network faults injected by the proxy, in each direction of every connection.
+type FaultProfile struct {
+	Latency time.Duration
+	// Jitter is the maximum random delay added to Latency. The proxy never reorders the data of a connection.
+	Jitter time.Duration
+	// Bandwidth is the maximum throughput, in bytes per second, 0 for unlimited
+	Bandwidth int64
+	// StallRate is the probability of each forwarded chunk to be held during Stall, as a dropped packet waiting for its retransmission would
+	StallRate float64
+	Stall     time.Duration
+	// ResetRate is the probability of each forwarded chunk to reset the connection instead of being forwarded
+	ResetRate float64
+}
+
+// FaultProxy forwards the connections accepted on Listen to Target, injecting the faults of its profile.
+type FaultProxy struct {
+	Listen   string
+	Target   string
+	profile  FaultProfile
+	listener net.Listener
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+}
+
+// proxyChunk is a piece of data read from one side, forwarded to the other once due.
+type proxyChunk struct {
+	data []byte
+	due  time.Time
+}
+
+// StartFaultProxy listens on listen ( use port 0 for an ephemeral one ) and serves the connections in the background.
+func StartFaultProxy(listen string, target string, profile FaultProfile) (*FaultProxy, error) {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+	p := &FaultProxy{Listen: listener.Addr().String(), Target: target, profile: profile, listener: listener, conns: map[net.Conn]struct{}{}}
+	go p.serve()
+	return p, nil
+}
+
+func (p *FaultProxy) serve() {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.handle(client)
+	}
+}
+
+func (p *FaultProxy) handle(client net.Conn) {
+	server, err := net.DialTimeout("tcp", p.Target, 5*time.Second)
+	if err != nil {
+		log.Printf("Fault proxy %s unable to connect to %s: %v", p.Listen, p.Target, err)
+		client.Close()
+		return
+	}
+	p.mu.Lock()
+	p.conns[client], p.conns[server] = struct{}{}, struct{}{}
+	p.mu.Unlock()
+	done := make(chan struct{})
+	var once sync.Once
+	closeBoth := func(reset bool) {
+		once.Do(func() {
+			close(done)
+			for _, conn := range []net.Conn{client, server} {
+				// a zero linger sends a RST instead of a FIN
+				if tcp, ok := conn.(*net.TCPConn); ok && reset {
+					tcp.SetLinger(0)
+				}
+				conn.Close()
+				p.mu.Lock()
+				delete(p.conns, conn)
+				p.mu.Unlock()
+			}
+		})
+	}
+	go p.pipe(server, client, done, closeBoth)
+	p.pipe(client, server, done, closeBoth)
+}
+
+// pipe forwards src to dst until either side is closed, delaying, throttling, stalling or resetting the data as the profile says.
+func (p *FaultProxy) pipe(dst net.Conn, src net.Conn, done chan struct{}, closeBoth func(reset bool)) {
+	queue := make(chan proxyChunk, 1024)
+	go func() {
+		defer close(queue)
+		var last time.Time
+		for {
+			buf := make([]byte, 32*1024)
+			n, err := src.Read(buf)
+			if n > 0 {
+				due := time.Now().Add(p.profile.Latency)
+				if p.profile.Jitter > 0 {
+					due = due.Add(time.Duration(rand.Int63n(int64(p.profile.Jitter))))
+				}
+				if due.Before(last) {
+					due = last
+				}
+				last = due
+				select {
+				case queue <- proxyChunk{data: buf[:n], due: due}:
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	for chunk := range queue {
+		time.Sleep(time.Until(chunk.due))
+		if p.profile.ResetRate > 0 && rand.Float64() < p.profile.ResetRate {
+			CountDelivery("proxy-resets")
+			closeBoth(true)
+			return
+		}
+		if p.profile.StallRate > 0 && rand.Float64() < p.profile.StallRate {
+			CountDelivery("proxy-stalls")
+			time.Sleep(p.profile.Stall)
+		}
+		if _, err := dst.Write(chunk.data); err != nil {
+			break
+		}
+		if p.profile.Bandwidth > 0 {
+			time.Sleep(time.Duration(int64(len(chunk.data)) * int64(time.Second) / p.profile.Bandwidth))
+		}
+	}
+	closeBoth(false)
+}
+
+// Close stops listening and drops every proxied connection.
+func (p *FaultProxy) Close() {
+	p.listener.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for conn := range p.conns {
+		conn.Close()
+	}
+}
+
+var faultProxyProfile *FaultProfile
+var faultProxies = map[string]*FaultProxy{}
+var faultProxiesMutex sync.Mutex
+
+// EnableFaultProxy routes the subscriber and publisher connections of every tcp node through an in-process proxy injecting the profile faults.
+func EnableFaultProxy(profile FaultProfile) {
+	faultProxiesMutex.Lock()
+	defer faultProxiesMutex.Unlock()
+	faultProxyProfile = &profile
+}
+
+func faultProxyEnabled() bool {
+	faultProxiesMutex.Lock()
+	defer faultProxiesMutex.Unlock()
+	return faultProxyProfile != nil
+}
+
+// proxyAddress returns the address to dial to reach a node, starting its in-process proxy on first use.
+func proxyAddress(addr string) string {
+	if endpointFor(addr).Network != "tcp" {
+		return addr
+	}
+	faultProxiesMutex.Lock()
+	defer faultProxiesMutex.Unlock()
+	if faultProxyProfile == nil {
+		return addr
+	}
+	if proxy, found := faultProxies[addr]; found {
+		return proxy.Listen
+	}
+	proxy, err := StartFaultProxy("127.0.0.1:0", addr, *faultProxyProfile)
+	if err != nil {
+		log.Fatal(fmt.Errorf("unable to start the fault proxy of %s: %v", addr, err))
+	}
+	log.Printf("Fault proxy %s forwarding to %s", proxy.Listen, addr)
+	faultProxies[addr] = proxy
+	return proxy.Listen
+}
+
+// StopFaultProxies closes the in-process proxies and disables the fault injection, so that the next run dials the nodes directly.
+func StopFaultProxies() {
+	faultProxiesMutex.Lock()
+	defer faultProxiesMutex.Unlock()
+	faultProxyProfile = nil
+	for addr, proxy := range faultProxies {
+		proxy.Close()
+		delete(faultProxies, addr)
+	}
+}
@@ -0,0 +1,30 @@
+package subscribe
+
+import (
+	"net"
+	"testing"
+)
+
+func TestStopFaultProxies(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	EnableFaultProxy(FaultProfile{})
+	if !faultProxyEnabled() {
+		t.Fatal("the fault proxy is not enabled")
+	}
+	if proxied := proxyAddress(addr); proxied == addr {
+		t.Fatalf("proxyAddress(%s) did not start a proxy", addr)
+	}
+	StopFaultProxies()
+	if faultProxyEnabled() {
+		t.Error("the fault proxy is still enabled after StopFaultProxies")
+	}
+	if proxied := proxyAddress(addr); proxied != addr {
+		t.Errorf("proxyAddress(%s) = %s after StopFaultProxies, want the node address", addr, proxied)
+	}
+}
@@ -0,0 +1,212 @@
+package subscribe
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/rueian/rueidis"
+)
+
+// newPayload returns a message of dataSize bytes, or of the live data size, starting with the publish time in unix
+// nanoseconds, so that subscribers can compute the end-to-end latency.
+func newPayload(dataSize int) string {
+	dataSize = payloadSize(dataSize)
+	payload := strconv.FormatInt(time.Now().UnixNano(), 10) + " "
+	if len(payload) < dataSize {
+		payload += strings.Repeat("x", dataSize-len(payload))
+	}
+	return payload
+}
+
+// newSequencedPayload is newPayload with the position of the message in its channel, used to detect lost and
+// duplicated deliveries.
+func newSequencedPayload(dataSize int, sequence uint64) string {
+	dataSize = payloadSize(dataSize)
+	payload := strconv.FormatInt(time.Now().UnixNano(), 10) + " " + strconv.FormatUint(sequence, 10) + " "
+	if len(payload) < dataSize {
+		payload += strings.Repeat("x", dataSize-len(payload))
+	}
+	return payload
+}
+
+// payloadSequence extracts the sequence number from a message built by newSequencedPayload.
+func payloadSequence(message string) (uint64, bool) {
+	fields := strings.SplitN(message, " ", 3)
+	if len(fields) < 3 {
+		return 0, false
+	}
+	sequence, err := strconv.ParseUint(fields[1], 10, 64)
+	return sequence, err == nil
+}
+
+// payloadLatency extracts the publish time from a message built by newPayload.
+// Messages not produced by the built-in publishers are ignored.
+func payloadLatency(message string) (time.Duration, bool) {
+	end := strings.IndexByte(message, ' ')
+	if end <= 0 {
+		return 0, false
+	}
+	ns, err := strconv.ParseInt(message[:end], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	latency := time.Since(time.Unix(0, ns))
+	if latency < 0 || latency > time.Minute {
+		return 0, false
+	}
+	return latency, true
+}
+
+// recordPayloadLatency records the end-to-end latency of a message, if it carries a publish timestamp.
+func recordPayloadLatency(message string) {
+	if latency, ok := payloadLatency(message); ok {
+		RecordLatency(latency)
+	}
+}
+
+// publishInterval is the time between two messages of a publisher sending publishRate messages per second.
+func publishInterval(publishRate float64) time.Duration {
+	interval := time.Duration(float64(time.Second) / publishRate)
+	if interval <= 0 {
+		interval = time.Nanosecond
+	}
+	return interval
+}
+
+// publishPacer ticks at the pace of a built-in publisher, following the live publish rate when one is set.
+type publishPacer struct {
+	C       <-chan time.Time
+	ticker  *time.Ticker
+	mu      sync.Mutex
+	stopped bool
+}
+
+// publishTicker paces the built-in publishers at publishRate messages per second, or at the live publish rate.
+func publishTicker(publishRate int) *publishPacer {
+	rate := currentLivePublishRate()
+	live := rate > 0
+	if !live {
+		rate = float64(publishRate)
+	}
+	ticker := time.NewTicker(publishInterval(rate))
+	pacer := &publishPacer{C: ticker.C, ticker: ticker}
+	if live {
+		go pacer.follow(rate)
+	}
+	return pacer
+}
+
+// follow adjusts the tick interval to the live publish rate until the pacer is stopped.
+func (p *publishPacer) follow(rate float64) {
+	for {
+		time.Sleep(liveControlInterval)
+		next := currentLivePublishRate()
+		p.mu.Lock()
+		stopped := p.stopped
+		if !stopped && next > 0 && next != rate {
+			p.ticker.Reset(publishInterval(next))
+			rate = next
+		}
+		p.mu.Unlock()
+		if stopped {
+			return
+		}
+	}
+}
+
+func (p *publishPacer) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = true
+	p.ticker.Stop()
+}
+
+// PublishedMessages is the number of messages successfully sent by the built-in publishers.
+var PublishedMessages uint64
+
+// publishFunc sends a single message with the client shared by the built-in publishers.
+type publishFunc func(channel string, sharded bool, message string) error
+
+// newPublishFunc uses rueidis, unless announced addresses are remapped or the connections go through the fault
+// proxies: rueidis always connects to the addresses announced by the cluster, while the go-redis dialer can be overridden.
+// The returned close function releases the client once the publishers are done.
+func newPublishFunc(nodes []string) (publishFunc, func()) {
+	ctx := context.Background()
+	if sentinelEnabled() {
+		return newSentinelPublishFunc()
+	}
+	if addressMapActive() || faultProxyEnabled() {
+		var client goredis.UniversalClient = goredis.NewClient(goRedisOptions(nodes[0], credentialsFor(nodes[0]), "pubsub-bench-publisher"))
+		// use the cluster client when the node has cluster mode enabled, as rueidis does
+		if err := client.ClusterInfo(ctx).Err(); err == nil {
+			client.Close()
+			client = goredis.NewClusterClient(goRedisClusterOptions(nodes, credentialsFor(nodes[0]), "pubsub-bench-publisher"))
+		}
+		return func(channel string, sharded bool, message string) error {
+			if sharded {
+				return client.SPublish(ctx, channel, message).Err()
+			}
+			return client.Publish(ctx, channel, message).Err()
+		}, func() { client.Close() }
+	}
+	client, err := rueidis.NewClient(rueidisClientOption(nodes))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return func(channel string, sharded bool, message string) error {
+		if sharded {
+			return client.Do(ctx, client.B().Spublish().Channel(channel).Message(message).Build()).Error()
+		}
+		return client.Do(ctx, client.B().Publish().Channel(channel).Message(message).Build()).Error()
+	}, client.Close
+}
+
+func PublisherRoutine(publish publishFunc, channel string, sharded bool, dataSize int, publishRate int, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	tick := publishTicker(publishRate)
+	defer tick.Stop()
+	// the sequence only moves forward on success, so that failed publishes are not reported as lost
+	sequence := uint64(0)
+	for {
+		select {
+		case <-tick.C:
+			if err := publish(channel, sharded, newSequencedPayload(dataSize, sequence+1)); err != nil {
+				CountDelivery("publish-errors")
+				log.Printf("Error while publishing to channel %s: %v", channel, err)
+				continue
+			}
+			sequence++
+			atomic.AddUint64(&PublishedMessages, 1)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// startRedisPublishers launches one built-in publisher per channel, when --publish-rate is set.
+func startRedisPublishers(nodes []string, sharded bool, stopChan chan struct{}, wg *sync.WaitGroup, channel_maximum int, channel_minimum int, subscribe_prefix string, data_size int, publish_rate int) {
+	if publish_rate <= 0 {
+		return
+	}
+	publish, closeClient := newPublishFunc(nodes)
+	publishers := sync.WaitGroup{}
+	for channel_id := channel_minimum; channel_id <= channel_maximum; channel_id++ {
+		channel := subscribe_prefix + strconv.Itoa(channel_id)
+		publishers.Add(1)
+		go PublisherRoutine(publish, channel, sharded, data_size, publish_rate, stopChan, &publishers)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		publishers.Wait()
+		closeClient()
+	}()
+}
@@ -0,0 +1,249 @@
+package subscribe
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/rueian/rueidis"
+)
+
+var RedisClientRueidis string = "rueidis"
+var RedisClientGoRedis string = "go-redis"
+var RedisClientResp string = "resp"
+var RedisClientChoices []string = []string{RedisClientRueidis, RedisClientGoRedis, RedisClientResp}
+
+// pubsubReceiver is the subset of a redis client library used by the (sharded) pub/sub subscribers.
+type pubsubReceiver interface {
+	// Receive subscribes to the channel and calls fn for every message, until the receiver is closed.
+	// subscribed is called once the server confirmed the subscription, if the library exposes it.
+	Receive(channel string, sharded bool, subscribed func(), fn func(channel string, message string)) error
+	Close()
+}
+
+func newPubsubReceiver(library string, addr string, credentials RedisCredentials, subscriberName string, channel string) (pubsubReceiver, error) {
+	switch library {
+	case RedisClientRueidis:
+		conn, err := BootstrapPubSub(addr, credentials, subscriberName, channel)
+		return &rueidisReceiver{conn: conn}, err
+	case RedisClientGoRedis:
+		return newGoRedisReceiver(addr, credentials, subscriberName)
+	case RedisClientResp:
+		return &respReceiver{addr: addr, credentials: credentials, subscriberName: subscriberName}, nil
+	}
+	return nil, fmt.Errorf("unsupported redis client %s ( choices %s )", library, strings.Join(RedisClientChoices, ","))
+}
+
+type rueidisReceiver struct {
+	conn rueidis.Client
+}
+
+// rueidis does not expose the subscription confirmation, so the subscription is confirmed with CLIENT INFO on the same
+// connection, which reports the channels the connection is subscribed to. subscribed is never called by the servers
+// without CLIENT INFO ( before redis 6.2 ).
+func (r *rueidisReceiver) Receive(channel string, sharded bool, subscribed func(), fn func(channel string, message string)) error {
+	handler := func(msg rueidis.PubSubMessage) {
+		fn(msg.Channel, msg.Message)
+	}
+	field := "sub"
+	if sharded {
+		field = "ssub"
+	}
+	confirm := subscribed
+	for {
+		done := make(chan struct{})
+		confirmed := sync.WaitGroup{}
+		confirmed.Add(1)
+		go func() {
+			// tell the caller we've stopped
+			defer confirmed.Done()
+			if r.confirmSubscription(field, done) {
+				confirm()
+				confirm = func() {}
+			}
+		}()
+		var err error
+		if sharded {
+			err = r.conn.Receive(context.Background(), r.conn.B().Ssubscribe().Channel(channel).Build(), handler)
+		} else {
+			err = r.conn.Receive(context.Background(), r.conn.B().Subscribe().Channel(channel).Build(), handler)
+		}
+		close(done)
+		confirmed.Wait()
+		if err == rueidis.ErrClosing {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// the server dropped the subscription: subscribe again, without hammering it
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// confirmSubscription polls CLIENT INFO until the connection reports a subscription in field, returning false if
+// done is closed first or the server does not support CLIENT INFO.
+func (r *rueidisReceiver) confirmSubscription(field string, done chan struct{}) bool {
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-done:
+			return false
+		case <-tick.C:
+		}
+		info, err := r.conn.Do(context.Background(), r.conn.B().ClientInfo().Build()).ToString()
+		if err != nil {
+			return false
+		}
+		if clientInfoCount(info, field) > 0 {
+			return true
+		}
+	}
+}
+
+// clientInfoCount returns the value of a numeric field of a CLIENT INFO or CLIENT LIST line, 0 if it is missing.
+func clientInfoCount(info string, field string) int64 {
+	for _, pair := range strings.Fields(info) {
+		if name, value, found := strings.Cut(pair, "="); found && name == field {
+			count, _ := strconv.ParseInt(value, 10, 64)
+			return count
+		}
+	}
+	return 0
+}
+
+func (r *rueidisReceiver) Close() {
+	r.conn.Close()
+}
+
+type goRedisReceiver struct {
+	mu     sync.Mutex
+	client goredis.UniversalClient
+	pubsub *goredis.PubSub
+}
+
+func newGoRedisReceiver(addr string, credentials RedisCredentials, subscriberName string) (*goRedisReceiver, error) {
+	ctx := context.Background()
+	var client goredis.UniversalClient = goredis.NewClient(goRedisOptions(addr, credentials, subscriberName))
+	// use the cluster client when the node has cluster mode enabled, as rueidis does
+	if err := client.ClusterInfo(ctx).Err(); err == nil {
+		client.Close()
+		client = goredis.NewClusterClient(goRedisClusterOptions([]string{addr}, credentials, subscriberName))
+	}
+	return &goRedisReceiver{client: client}, client.Ping(ctx).Err()
+}
+
+func (r *goRedisReceiver) Receive(channel string, sharded bool, subscribed func(), fn func(channel string, message string)) error {
+	ctx := context.Background()
+	var pubsub *goredis.PubSub
+	if sharded {
+		pubsub = r.client.SSubscribe(ctx, channel)
+	} else {
+		pubsub = r.client.Subscribe(ctx, channel)
+	}
+	r.mu.Lock()
+	r.pubsub = pubsub
+	r.mu.Unlock()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+	subscribed()
+	for msg := range pubsub.Channel() {
+		fn(msg.Channel, msg.Payload)
+	}
+	return nil
+}
+
+func (r *goRedisReceiver) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pubsub != nil {
+		r.pubsub.Close()
+	}
+	r.client.Close()
+}
+
+// respReceiver uses the minimal hand-written RESP reader, following a single MOVED redirection on subscribe.
+type respReceiver struct {
+	mu             sync.Mutex
+	addr           string
+	credentials    RedisCredentials
+	subscriberName string
+	conn           *respConn
+}
+
+func (r *respReceiver) Receive(channel string, sharded bool, subscribed func(), fn func(channel string, message string)) (err error) {
+	command, kind := "SUBSCRIBE", "message"
+	if sharded {
+		command, kind = "SSUBSCRIBE", "smessage"
+	}
+	addr := r.addr
+	var conn *respConn
+	for redirects := 0; ; redirects++ {
+		if conn, err = dialRespThrough(addr, proxyAddress(addr), r.credentials); err != nil {
+			return err
+		}
+		r.mu.Lock()
+		r.conn = conn
+		r.mu.Unlock()
+		if _, err = conn.Do("CLIENT", "SETNAME", r.subscriberName); err != nil {
+			return err
+		}
+		if err = conn.Send(command, channel); err != nil {
+			return err
+		}
+		reply, err := conn.ReadReply()
+		if err != nil {
+			return err
+		}
+		rerr, redirected := reply.(respError)
+		if !redirected {
+			break
+		}
+		// -MOVED <slot> <host>:<port>
+		fields := strings.Fields(string(rerr))
+		if redirects > 0 || len(fields) != 3 || fields[0] != "MOVED" {
+			return rerr
+		}
+		conn.Close()
+		// let the subscriber be placed again using the refreshed topology
+		if sharded && liveTopologyEnabled {
+			return errSlotMigrated
+		}
+		addr = mapAddress(fields[2])
+	}
+	subscribed()
+	for {
+		reply, err := conn.ReadReply()
+		if err != nil {
+			return err
+		}
+		msg, ok := reply.([]interface{})
+		if !ok || len(msg) != 3 {
+			continue
+		}
+		// redis unsubscribes the sharded subscribers of a slot once it is migrated
+		if msg[0] == "sunsubscribe" && msg[1] == channel {
+			return errSlotMigrated
+		}
+		if msg[0] != kind {
+			continue
+		}
+		msgChannel, _ := msg[1].(string)
+		message, _ := msg[2].(string)
+		fn(msgChannel, message)
+	}
+}
+
+func (r *respReceiver) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn != nil {
+		r.conn.Close()
+	}
+}
@@ -0,0 +1,63 @@
+package subscribe
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientInfoCount(t *testing.T) {
+	info := "id=3 addr=127.0.0.1:50188 name=subscriber#1-channel1 db=0 sub=1 psub=0 ssub=2 multi=-1\n"
+	tests := []struct {
+		field string
+		want  int64
+	}{
+		{"sub", 1},
+		{"psub", 0},
+		{"ssub", 2},
+		{"unknown", 0},
+		// a prefix of a field name is not the field
+		{"ss", 0},
+	}
+	for _, tt := range tests {
+		if got := clientInfoCount(info, tt.field); got != tt.want {
+			t.Errorf("clientInfoCount(%s) = %d, want %d", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestRespReceiverConnectionLost(t *testing.T) {
+	node := startFakePubSubNode(t)
+	receiver := &respReceiver{addr: node.listener.Addr().String(), subscriberName: "subscriber#1-channel1"}
+	defer receiver.Close()
+	subscribed := make(chan struct{})
+	received := make(chan struct{}, 1)
+	result := make(chan error, 1)
+	go func() {
+		result <- receiver.Receive("channel1", false, func() { close(subscribed) }, func(channel string, message string) {
+			select {
+			case received <- struct{}{}:
+			default:
+			}
+		})
+	}()
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	select {
+	case <-subscribed:
+	default:
+		t.Error("the subscription was not confirmed before the first message")
+	}
+	// drop the subscriber connection
+	node.close()
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("Receive returned no error after losing its connection")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Receive did not return after losing its connection")
+	}
+}
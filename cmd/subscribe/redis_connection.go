@@ -0,0 +1,144 @@
+package subscribe
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/rueian/rueidis"
+)
+
+// RedisConnectionOptions are the credentials and TLS settings applied to every redis connection the tool opens.
+type RedisConnectionOptions struct {
+	Username           string
+	Password           string
+	TLS                bool
+	CACert             string
+	Cert               string
+	Key                string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// RedisCredentials are the AUTH username and password of a single connection.
+type RedisCredentials struct {
+	Username string
+	Password string
+}
+
+var redisConnection RedisConnectionOptions
+var redisTLSConfig *tls.Config
+
+// SetRedisConnectionOptions validates the options and loads the certificates. It must be called before any connection is opened.
+func SetRedisConnectionOptions(options RedisConnectionOptions) error {
+	redisConnection = options
+	redisTLSConfig = nil
+	if !options.TLS {
+		if options.CACert != "" || options.Cert != "" || options.Key != "" {
+			return fmt.Errorf("--tls-ca-cert, --tls-cert and --tls-key require --tls")
+		}
+		return nil
+	}
+	config := &tls.Config{ServerName: options.ServerName, InsecureSkipVerify: options.InsecureSkipVerify}
+	if options.CACert != "" {
+		pem, err := ioutil.ReadFile(options.CACert)
+		if err != nil {
+			return err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", options.CACert)
+		}
+	}
+	if (options.Cert == "") != (options.Key == "") {
+		return fmt.Errorf("--tls-cert and --tls-key must be specified together")
+	}
+	if options.Cert != "" {
+		certificate, err := tls.LoadX509KeyPair(options.Cert, options.Key)
+		if err != nil {
+			return err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	redisTLSConfig = config
+	return nil
+}
+
+// tlsConfigFor returns a copy of the TLS settings used to connect to a node, or nil when TLS is disabled.
+// Without --tls-server-name the server name is taken from the address of each node.
+func tlsConfigFor(addr string) *tls.Config {
+	if redisTLSConfig != nil {
+		return redisTLSConfig.Clone()
+	}
+	if endpointFor(addr).TLS {
+		return &tls.Config{}
+	}
+	return nil
+}
+
+// rueidisClientOption uses the settings of the first address for the whole client, since rueidis shares them among every node.
+func rueidisClientOption(addrs []string) rueidis.ClientOption {
+	credentials := credentialsFor(addrs[0])
+	return rueidis.ClientOption{
+		InitAddress: addrs,
+		Username:    credentials.Username,
+		Password:    credentials.Password,
+		TLSConfig:   tlsConfigFor(addrs[0]),
+	}
+}
+
+func goRedisOptions(addr string, credentials RedisCredentials, clientName string) *goredis.Options {
+	options := &goredis.Options{
+		Network:    endpointFor(addr).Network,
+		Addr:       addr,
+		ClientName: clientName,
+		Username:   credentials.Username,
+		Password:   credentials.Password,
+		TLSConfig:  tlsConfigFor(addr),
+	}
+	if faultProxyEnabled() {
+		options.Dialer = goRedisClusterDial
+	}
+	return options
+}
+
+func goRedisClusterOptions(addrs []string, credentials RedisCredentials, clientName string) *goredis.ClusterOptions {
+	return &goredis.ClusterOptions{
+		Addrs:      addrs,
+		ClientName: clientName,
+		Username:   credentials.Username,
+		Password:   credentials.Password,
+		Dialer:     goRedisClusterDial,
+	}
+}
+
+// goRedisClusterDial connects to the reachable address of the nodes announced by the cluster, through
+// their fault proxy if any. It replaces the go-redis dialer, so it also takes care of TLS.
+func goRedisClusterDial(ctx context.Context, network string, addr string) (net.Conn, error) {
+	addr = mapAddress(addr)
+	via := proxyAddress(addr)
+	dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 5 * time.Minute}
+	if config := tlsConfigFor(addr); config != nil {
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		return (&tls.Dialer{NetDialer: dialer, Config: config}).DialContext(ctx, network, via)
+	}
+	return dialer.DialContext(ctx, network, via)
+}
+
+// respAuthArgs returns the AUTH command for the credentials, or nil if no password is set.
+func respAuthArgs(credentials RedisCredentials) []string {
+	if credentials.Password == "" {
+		return nil
+	}
+	if credentials.Username != "" {
+		return []string{"AUTH", credentials.Username, credentials.Password}
+	}
+	return []string{"AUTH", credentials.Password}
+}
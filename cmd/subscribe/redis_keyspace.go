@@ -0,0 +1,237 @@
+package subscribe
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rueian/rueidis"
+)
+
+// keyspaceWrites keeps the time of the last write per "<key> <event>", used to compute the notification lag.
+var keyspaceWrites sync.Map
+
+// KeyspaceSubscriberRoutine receives the keyspace and keyevent notifications of the keys starting with subscribe_prefix.
+// Every write is notified on both kinds of channels when both are enabled, so only one kind is counted as messages,
+// the keyevent ones when countKeyevent is set, while both are reported as delivery stats.
+func KeyspaceSubscriberRoutine(addr string, subscriberName string, patterns []string, subscribe_prefix string, countKeyevent bool, printMessages bool, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	conn, err := keyspaceSubscribe(addr, subscriberName, patterns)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the connection is replaced when reconnecting after a chaos action
+	var connMutex sync.Mutex
+	go func() {
+		<-stop
+		connMutex.Lock()
+		conn.Close()
+		connMutex.Unlock()
+	}()
+
+	for {
+		reply, err := conn.ReadReply()
+		if err != nil {
+			if !chaosActive() {
+				return
+			}
+			var next *respConn
+			for next == nil {
+				select {
+				case <-stop:
+					return
+				case <-time.After(100 * time.Millisecond):
+				}
+				next, _ = keyspaceSubscribe(addr, subscriberName, patterns)
+			}
+			connMutex.Lock()
+			select {
+			case <-stop:
+				connMutex.Unlock()
+				next.Close()
+				return
+			default:
+			}
+			conn = next
+			connMutex.Unlock()
+			CountDelivery("subscriber-reconnects")
+			continue
+		}
+		msg, ok := reply.([]interface{})
+		if !ok || len(msg) != 4 || msg[0] != "pmessage" {
+			continue
+		}
+		channel, _ := msg[2].(string)
+		message, _ := msg[3].(string)
+		if printMessages {
+			fmt.Println(fmt.Sprintf("received notification in channel %s. Message: %s", channel, message))
+		}
+		// __keyspace@<db>__:<key> carries the event, __keyevent@<db>__:<event> carries the key
+		key, event := message, message
+		keyevent := !strings.HasPrefix(channel, "__keyspace@")
+		if pos := strings.Index(channel, "__:"); pos >= 0 {
+			if keyevent {
+				event = channel[pos+3:]
+			} else {
+				key = channel[pos+3:]
+			}
+		}
+		// the keyevent pattern matches the events of every key of the server
+		if !strings.HasPrefix(key, subscribe_prefix) {
+			continue
+		}
+		if keyevent {
+			CountDelivery("keyevent-notifications")
+		} else {
+			CountDelivery("keyspace-notifications")
+		}
+		if keyevent != countKeyevent {
+			continue
+		}
+		if written, found := keyspaceWrites.Load(key + " " + event); found {
+			RecordLatency(time.Since(written.(time.Time)))
+		}
+		atomic.AddUint64(&TotalMessages, 1)
+	}
+}
+
+// keyspaceSubscribe connects to the node addr and subscribes to the notification patterns.
+func keyspaceSubscribe(addr string, subscriberName string, patterns []string) (*respConn, error) {
+	conn, err := dialResp(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Do("CLIENT", "SETNAME", subscriberName); err == nil {
+		err = conn.Send(append([]string{"PSUBSCRIBE"}, patterns...)...)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func KeyspaceWriterRoutine(client rueidis.Client, key string, publishRate int, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	ctx := context.Background()
+	tick := publishTicker(publishRate)
+	defer tick.Stop()
+	for op := 0; ; op++ {
+		select {
+		case <-tick.C:
+			var err error
+			event := ""
+			written := time.Now()
+			switch op % 3 {
+			case 0:
+				event = "set"
+				keyspaceWrites.Store(key+" "+event, written)
+				err = client.Do(ctx, client.B().Set().Key(key).Value(key).Build()).Error()
+			case 1:
+				event = "expire"
+				keyspaceWrites.Store(key+" "+event, written)
+				err = client.Do(ctx, client.B().Expire().Key(key).Seconds(3600).Build()).Error()
+			case 2:
+				event = "del"
+				keyspaceWrites.Store(key+" "+event, written)
+				err = client.Do(ctx, client.B().Del().Key(key).Build()).Error()
+			}
+			if err != nil {
+				log.Printf("Error while issuing %s on key %s: %v", event, key, err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func RedisKeyspaceNotificationsLogic(debug int, stopChan chan struct{}, wg *sync.WaitGroup, distributeSubscribers bool, host string, port string, keyspace_events string, channel_maximum int, channel_minimum int, subscribers_per_channel int, subscribe_prefix string, publish_rate int) {
+	nodes, _ := getNodesInfo(distributeSubscribers, host, port)
+	printMessages := false
+	if debug >= 2 {
+		printMessages = true
+	}
+
+	for _, addr := range nodes {
+		conn, err := dialResp(addr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reply, err := conn.Do("CONFIG", "GET", "notify-keyspace-events")
+		if err != nil {
+			log.Fatal(err)
+		}
+		previous := ""
+		if values, ok := reply.([]interface{}); ok && len(values) == 2 {
+			previous, _ = values[1].(string)
+		}
+		if _, err = conn.Do("CONFIG", "SET", "notify-keyspace-events", keyspace_events); err != nil {
+			log.Fatal(err)
+		}
+		conn.Close()
+		if debug >= 1 {
+			log.Printf("Node %s notify-keyspace-events changed from \"%s\" to \"%s\"", addr, previous, keyspace_events)
+		}
+		wg.Add(1)
+		go func(addr string, previous string) {
+			defer wg.Done()
+			<-stopChan
+			// a connection idle during the whole run could have been closed by the server timeout
+			conn, err := dialResp(addr)
+			if err == nil {
+				defer conn.Close()
+				_, err = conn.Do("CONFIG", "SET", "notify-keyspace-events", previous)
+			}
+			if err != nil {
+				log.Printf("Unable to restore notify-keyspace-events on node %s: %v", addr, err)
+				return
+			}
+			if debug >= 1 {
+				log.Printf("Node %s notify-keyspace-events restored to \"%s\"", addr, previous)
+			}
+		}(addr, previous)
+	}
+
+	patterns := []string{fmt.Sprintf("__keyspace@*__:%s*", subscribe_prefix), "__keyevent@*__:*"}
+	// count the keyspace notifications, unless only the keyevent ones are enabled
+	countKeyevent := !strings.Contains(keyspace_events, "K")
+	// the keyspace subscribers are per node rather than per channel
+	startSubscribers(stopChan, wg, len(nodes)-1, 0, subscribers_per_channel, func(nodes_pos int, subscriber_number int, stop chan struct{}) {
+		addr := nodes[nodes_pos]
+		subscriberName := fmt.Sprintf("subscriber#%d-keyspace-node%d", subscriber_number, nodes_pos)
+		if debug >= 1 {
+			log.Printf("Keyspace subscriber #%d using node=%d (%s)", subscriber_number, nodes_pos, addr)
+		}
+		wg.Add(1)
+		go KeyspaceSubscriberRoutine(addr, subscriberName, patterns, subscribe_prefix, countKeyevent, printMessages, stop, wg)
+	})
+
+	if publish_rate <= 0 {
+		log.Println("Built-in publishers are disabled (--publish-rate 0). Waiting for external keyspace traffic.")
+		return
+	}
+	client, err := rueidis.NewClient(rueidisClientOption(nodes))
+	if err != nil {
+		log.Fatal(err)
+	}
+	writers := sync.WaitGroup{}
+	for channel_id := channel_minimum; channel_id <= channel_maximum; channel_id++ {
+		key := fmt.Sprintf("%s%d", subscribe_prefix, channel_id)
+		writers.Add(1)
+		go KeyspaceWriterRoutine(client, key, publish_rate, stopChan, &writers)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		writers.Wait()
+		client.Close()
+	}()
+}
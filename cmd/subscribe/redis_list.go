@@ -0,0 +1,165 @@
+package subscribe
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rueian/rueidis"
+)
+
+var listPopBrpop string = "brpop"
+var listPopBlmove string = "blmove"
+
+// listProcessingKey returns the BLMOVE destination of a queue. The hash tag keeps both keys in the same slot.
+func listProcessingKey(queue string) string {
+	return fmt.Sprintf("{%s}:processing", queue)
+}
+
+func ListConsumerRoutine(addr string, consumerName string, queue string, popCommand string, blockTimeout float64, printMessages bool, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	conn, err := BootstrapPubSub(addr, credentialsFor(addr), consumerName, queue)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+	processing := listProcessingKey(queue)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		var message string
+		var err error
+		waitStart := time.Now()
+		if popCommand == listPopBlmove {
+			message, err = conn.Do(ctx, conn.B().Blmove().Source(queue).Destination(processing).Right().Left().Timeout(blockTimeout).Build()).ToString()
+		} else {
+			var reply []string
+			reply, err = conn.Do(ctx, conn.B().Brpop().Key(queue).Timeout(blockTimeout).Build()).AsStrSlice()
+			if len(reply) == 2 {
+				message = reply[1]
+			}
+		}
+		atomic.AddInt64(&IdleTime, int64(time.Since(waitStart)))
+		if err != nil {
+			if !rueidis.IsRedisNil(err) {
+				log.Printf("Error while popping from queue %s: %v", queue, err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		if printMessages {
+			fmt.Println(fmt.Sprintf("received message in queue %s. Message: %s", queue, message))
+		}
+		recordPayloadLatency(message)
+		atomic.AddUint64(&TotalMessages, 1)
+		if popCommand == listPopBlmove {
+			if err := conn.Do(ctx, conn.B().Lrem().Key(processing).Count(1).Element(message).Build()).Error(); err != nil {
+				log.Printf("Error while removing message from %s: %v", processing, err)
+			}
+		}
+	}
+}
+
+func ListProducerRoutine(client rueidis.Client, queue string, dataSize int, publishRate int, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	ctx := context.Background()
+	tick := publishTicker(publishRate)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if err := client.Do(ctx, client.B().Lpush().Key(queue).Element(newPayload(dataSize)).Build()).Error(); err != nil {
+				log.Printf("Error while pushing to queue %s: %v", queue, err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// ListDepthRoutine periodically sums the length of every queue into Backlog.
+func ListDepthRoutine(client rueidis.Client, queues []string, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	ctx := context.Background()
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			depth := int64(0)
+			for _, queue := range queues {
+				length, err := client.Do(ctx, client.B().Llen().Key(queue).Build()).ToInt64()
+				if err != nil {
+					continue
+				}
+				depth += length
+			}
+			atomic.StoreInt64(&Backlog, depth)
+		case <-stop:
+			return
+		}
+	}
+}
+
+func RedisListLogic(debug int, stopChan chan struct{}, wg *sync.WaitGroup, distributeSubscribers bool, host string, port string, channel_maximum int, channel_minimum int, subscribers_per_channel int, subscribe_prefix string, pop_command string, block_timeout float64, data_size int, publish_rate int) {
+	nodes, node_subscriptions_count := getNodesInfo(distributeSubscribers, host, port)
+	printMessages := false
+	if debug >= 2 {
+		printMessages = true
+	}
+	if pop_command != listPopBrpop && pop_command != listPopBlmove {
+		log.Fatal(fmt.Errorf("unsupported list pop command %s ( choices %s,%s )", pop_command, listPopBrpop, listPopBlmove))
+	}
+	client, err := rueidis.NewClient(rueidisClientOption(nodes))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	queues := []string{}
+	for channel_id := channel_minimum; channel_id <= channel_maximum; channel_id++ {
+		queue := fmt.Sprintf("%s%d", subscribe_prefix, channel_id)
+		queues = append(queues, queue)
+	}
+	startSubscribers(stopChan, wg, channel_maximum, channel_minimum, subscribers_per_channel, func(channel_id int, channel_subscriber_number int, stop chan struct{}) {
+		queue := fmt.Sprintf("%s%d", subscribe_prefix, channel_id)
+		nodes_pos := channel_id % len(nodes)
+		node_subscriptions_count[nodes_pos]++
+		addr := nodes[nodes_pos]
+		consumerName := fmt.Sprintf("subscriber#%d-%s%d", channel_subscriber_number, subscribe_prefix, channel_id)
+		if debug >= 1 {
+			log.Printf("Queue %s consumer #%d using node=%d (%s)", queue, channel_subscriber_number, nodes_pos, addr)
+		}
+		wg.Add(1)
+		go ListConsumerRoutine(addr, consumerName, queue, pop_command, block_timeout, printMessages, stop, wg)
+	})
+
+	// the client is closed once the routines sharing it are done
+	clientUsers := sync.WaitGroup{}
+	clientUsers.Add(1)
+	go ListDepthRoutine(client, queues, stopChan, &clientUsers)
+	if publish_rate > 0 {
+		for _, queue := range queues {
+			clientUsers.Add(1)
+			go ListProducerRoutine(client, queue, data_size, publish_rate, stopChan, &clientUsers)
+		}
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		clientUsers.Wait()
+		client.Close()
+	}()
+}
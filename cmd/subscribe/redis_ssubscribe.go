@@ -1,63 +1,37 @@
 package subscribe
 
 import (
-	"context"
 	"fmt"
-	"github.com/rueian/rueidis"
+	"log"
 	"strings"
 	"sync"
-	"sync/atomic"
 )
 
-func ShardSubscriberRoutine(addr string, subscriberName string, channel string, printMessages bool, stop chan struct{}, wg *sync.WaitGroup) {
-	// tell the caller we've stopped
-	defer wg.Done()
-
-	conn, _ := BootstrapPubSub(addr, subscriberName, channel)
-	defer conn.Close()
-	sub := conn.B().Ssubscribe().Channel(channel).Build()
-	msgCh := make(chan rueidis.PubSubMessage)
-	go func() {
-		for {
-			conn.Receive(context.Background(), sub, func(msg rueidis.PubSubMessage) {
-				// handle the msg
-				msgCh <- msg
-			})
-		}
-	}()
-
-	for {
-		select {
-		case msg := <-msgCh:
-			if printMessages {
-				fmt.Println(fmt.Sprintf("received message in channel %s. Message: %s", msg.Channel, msg.Message))
-			}
-			atomic.AddUint64(&TotalMessages, 1)
-			break
-		case <-stop:
-			return
-		}
-	}
+func ShardSubscriberRoutine(clientLibrary string, addr string, role string, credentials RedisCredentials, subscriberName string, channel string, printMessages bool, stop chan struct{}, wg *sync.WaitGroup) {
+	pubsubSubscriberRoutine(clientLibrary, addr, role, credentials, subscriberName, channel, true, printMessages, stop, wg)
 }
 
-func RedisShardedPubSubLogic(debugLevel int, stopChan chan struct{}, wg *sync.WaitGroup, distributeSubscribers bool, host string, port string, client_output_buffer_limit_pubsub string, channel_maximum, channel_minimum, subscribers_per_channel int, subscribers_placement string, subscribe_prefix string) {
-	nodes, node_subscriptions_count := getNodesInfo(distributeSubscribers, host, port)
+func RedisShardedPubSubLogic(debugLevel int, stopChan chan struct{}, wg *sync.WaitGroup, distributeSubscribers bool, host string, port string, client_output_buffer_limit_pubsub string, channel_maximum, channel_minimum, subscribers_per_channel int, subscribers_placement string, subscribe_prefix string, client_library string, subscribe_on string, data_size int, publish_rate int) {
+	placement := newSubscriberPlacement(distributeSubscribers, host, port, subscribe_on)
 	printMessages := false
 	if debugLevel >= 2 {
 		printMessages = true
 	}
 
 	if strings.Compare(subscribers_placement, "dense") == 0 {
-		for channel_id := channel_minimum; channel_id <= channel_maximum; channel_id++ {
-			for channel_subscriber_number := 1; channel_subscriber_number <= subscribers_per_channel; channel_subscriber_number++ {
-				nodes_pos := channel_id % len(nodes)
-				node_subscriptions_count[nodes_pos]++
-				addr := nodes[nodes_pos]
-				channel := fmt.Sprintf("%s%d", subscribe_prefix, channel_id)
-				subscriberName := fmt.Sprintf("subscriber#%d-%s%d", channel_subscriber_number, subscribe_prefix, channel_id)
-				wg.Add(1)
-				go ShardSubscriberRoutine(addr, subscriberName, channel, printMessages, stopChan, wg)
+		startSubscribers(stopChan, wg, channel_maximum, channel_minimum, subscribers_per_channel, func(channel_id int, channel_subscriber_number int, stop chan struct{}) {
+			channel := fmt.Sprintf("%s%d", subscribe_prefix, channel_id)
+			addr, role := placement.pick(channel, channel_id, true)
+			subscriberName := fmt.Sprintf("subscriber#%d-%s%d", channel_subscriber_number, subscribe_prefix, channel_id)
+			if debugLevel >= 1 {
+				log.Printf("Channel %s subcriber #%d using node %s (%s)", channel, channel_subscriber_number, addr, role)
 			}
-		}
+			wg.Add(1)
+			go ShardSubscriberRoutine(client_library, addr, role, subscriberCredentials(channel_id, addr), subscriberName, channel, printMessages, stop, wg)
+		})
+	}
+	if debugLevel >= 1 {
+		placement.logCounts()
 	}
+	startRedisPublishers(placement.primaries(), true, stopChan, wg, channel_maximum, channel_minimum, subscribe_prefix, data_size, publish_rate)
 }
@@ -0,0 +1,186 @@
+package subscribe
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rueian/rueidis"
+)
+
+const streamBlockMs = 1000
+
+func StreamConsumerRoutine(addr string, consumerName string, stream string, group string, printMessages bool, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	conn, err := BootstrapPubSub(addr, credentialsFor(addr), consumerName, stream)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+	lastId := "$"
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		var result rueidis.RedisResult
+		if group != "" {
+			result = conn.Do(ctx, conn.B().Xreadgroup().Group(group, consumerName).Block(streamBlockMs).Streams().Key(stream).Id(">").Build())
+		} else {
+			result = conn.Do(ctx, conn.B().Xread().Block(streamBlockMs).Streams().Key(stream).Id(lastId).Build())
+		}
+		streams, err := result.AsMap()
+		if err != nil {
+			if !rueidis.IsRedisNil(err) {
+				log.Printf("Error while reading stream %s: %v", stream, err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		reply := streams[stream]
+		entries, _ := reply.ToArray()
+		ids := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			fields, _ := entry.ToArray()
+			if len(fields) != 2 {
+				continue
+			}
+			id, _ := fields[0].ToString()
+			values, _ := fields[1].AsStrSlice()
+			message := strings.Join(values, " ")
+			if len(values) == 2 {
+				message = values[1]
+			}
+			if printMessages {
+				fmt.Println(fmt.Sprintf("received entry %s in stream %s. Message: %s", id, stream, message))
+			}
+			recordPayloadLatency(message)
+			atomic.AddUint64(&TotalMessages, 1)
+			ids = append(ids, id)
+			lastId = id
+		}
+		if group != "" && len(ids) > 0 {
+			if err := conn.Do(ctx, conn.B().Xack().Key(stream).Group(group).Id(ids...).Build()).Error(); err != nil {
+				log.Printf("Error while acknowledging entries on stream %s: %v", stream, err)
+			}
+		}
+	}
+}
+
+func StreamProducerRoutine(client rueidis.Client, stream string, maxlen int64, dataSize int, publishRate int, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	ctx := context.Background()
+	tick := publishTicker(publishRate)
+	defer tick.Stop()
+	threshold := strconv.FormatInt(maxlen, 10)
+	for {
+		select {
+		case <-tick.C:
+			var err error
+			if maxlen > 0 {
+				err = client.Do(ctx, client.B().Xadd().Key(stream).Maxlen().Almost().Threshold(threshold).Id("*").FieldValue().FieldValue("data", newPayload(dataSize)).Build()).Error()
+			} else {
+				err = client.Do(ctx, client.B().Xadd().Key(stream).Id("*").FieldValue().FieldValue("data", newPayload(dataSize)).Build()).Error()
+			}
+			if err != nil {
+				log.Printf("Error while adding entry to stream %s: %v", stream, err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// StreamBacklogRoutine periodically sums the pending entries of the consumer group on every stream into Backlog.
+func StreamBacklogRoutine(client rueidis.Client, streams []string, group string, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	ctx := context.Background()
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			pending := int64(0)
+			for _, stream := range streams {
+				summary, err := client.Do(ctx, client.B().Xpending().Key(stream).Group(group).Build()).ToArray()
+				if err != nil || len(summary) == 0 {
+					continue
+				}
+				count, _ := summary[0].ToInt64()
+				pending += count
+			}
+			atomic.StoreInt64(&Backlog, pending)
+		case <-stop:
+			return
+		}
+	}
+}
+
+func RedisStreamsLogic(debug int, stopChan chan struct{}, wg *sync.WaitGroup, distributeSubscribers bool, host string, port string, channel_maximum int, channel_minimum int, subscribers_per_channel int, subscribe_prefix string, stream_group string, stream_maxlen int64, data_size int, publish_rate int) {
+	nodes, node_subscriptions_count := getNodesInfo(distributeSubscribers, host, port)
+	printMessages := false
+	if debug >= 2 {
+		printMessages = true
+	}
+	client, err := rueidis.NewClient(rueidisClientOption(nodes))
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+
+	streams := []string{}
+	for channel_id := channel_minimum; channel_id <= channel_maximum; channel_id++ {
+		stream := fmt.Sprintf("%s%d", subscribe_prefix, channel_id)
+		streams = append(streams, stream)
+		if stream_group != "" {
+			err := client.Do(ctx, client.B().XgroupCreate().Key(stream).Groupname(stream_group).Id("$").Mkstream().Build()).Error()
+			if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+				log.Fatal(err)
+			}
+		}
+	}
+	startSubscribers(stopChan, wg, channel_maximum, channel_minimum, subscribers_per_channel, func(channel_id int, channel_subscriber_number int, stop chan struct{}) {
+		stream := fmt.Sprintf("%s%d", subscribe_prefix, channel_id)
+		nodes_pos := channel_id % len(nodes)
+		node_subscriptions_count[nodes_pos]++
+		addr := nodes[nodes_pos]
+		consumerName := fmt.Sprintf("subscriber#%d-%s%d", channel_subscriber_number, subscribe_prefix, channel_id)
+		if debug >= 1 {
+			log.Printf("Stream %s consumer #%d using node=%d (%s)", stream, channel_subscriber_number, nodes_pos, addr)
+		}
+		wg.Add(1)
+		go StreamConsumerRoutine(addr, consumerName, stream, stream_group, printMessages, stop, wg)
+	})
+
+	// the client is closed once the routines sharing it are done
+	clientUsers := sync.WaitGroup{}
+	if stream_group != "" {
+		clientUsers.Add(1)
+		go StreamBacklogRoutine(client, streams, stream_group, stopChan, &clientUsers)
+	}
+	if publish_rate > 0 {
+		for _, stream := range streams {
+			clientUsers.Add(1)
+			go StreamProducerRoutine(client, stream, stream_maxlen, data_size, publish_rate, stopChan, &clientUsers)
+		}
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		clientUsers.Wait()
+		client.Close()
+	}()
+}
@@ -8,45 +8,154 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
-func SubscriberRoutine(addr string, subscriberName string, channel string, printMessages bool, stop chan struct{}, wg *sync.WaitGroup) {
+func SubscriberRoutine(clientLibrary string, addr string, role string, credentials RedisCredentials, subscriberName string, channel string, printMessages bool, stop chan struct{}, wg *sync.WaitGroup) {
+	pubsubSubscriberRoutine(clientLibrary, addr, role, credentials, subscriberName, channel, false, printMessages, stop, wg)
+}
+
+func pubsubSubscriberRoutine(clientLibrary string, addr string, role string, credentials RedisCredentials, subscriberName string, channel string, sharded bool, printMessages bool, stop chan struct{}, wg *sync.WaitGroup) {
 	// tell the caller we've stopped
 	defer wg.Done()
 
-	conn, _ := BootstrapPubSub(addr, subscriberName, channel)
-	defer conn.Close()
-	sub := conn.B().Subscribe().Channel(channel).Build()
-	msgCh := make(chan rueidis.PubSubMessage)
-	go func() {
-		for {
-			conn.Receive(context.Background(), sub, func(msg rueidis.PubSubMessage) {
-				// handle the msg
-				msgCh <- msg
-			})
-		}
+	// the subscribe latency includes the connection and AUTH handshake
+	start := time.Now()
+	receiver, err := newPubsubReceiver(clientLibrary, addr, credentials, subscriberName, channel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the receiver is replaced when following a sentinel failover or a slot migration, or after a fault proxy reset
+	var receiverMutex sync.Mutex
+	defer func() {
+		receiverMutex.Lock()
+		receiver.Close()
+		receiverMutex.Unlock()
 	}()
-
-	for {
-		select {
-		case msg := <-msgCh:
-			if printMessages {
-				fmt.Println(fmt.Sprintf("received message in channel %s. Message: %s", msg.Channel, msg.Message))
+	var live *liveSubscriber
+	if sharded && liveTopologyEnabled {
+		live = registerLiveSubscriber(channel, role, subscriberName, addr, func() {
+			receiverMutex.Lock()
+			receiver.Close()
+			receiverMutex.Unlock()
+		})
+		defer unregisterLiveSubscriber(live)
+	}
+	// the pending slot migration, closed by the first message received from the new node
+	var migration *SlotMigration
+	var lastMessage, migrationStart time.Time
+	// the tracker outlives the reconnections, so the messages missed while following a failover are counted
+	sequences := sequenceTracker{}
+	onMessage := func(msgChannel string, message string) {
+		if printMessages {
+			fmt.Println(fmt.Sprintf("received message in channel %s. Message: %s", msgChannel, message))
+		}
+		latency, hasLatency := payloadLatency(message)
+		if hasLatency {
+			RecordLatency(latency)
+		}
+		if role != "" {
+			recordRoleMessage(role, latency, hasLatency)
+		}
+		atomic.AddUint64(&TotalMessages, 1)
+		sequences.observe(message)
+		if live != nil {
+			lastMessage = time.Now()
+			if migration != nil {
+				recordDeliveryGap(migration, lastMessage.Sub(migrationStart))
+				migration = nil
 			}
-			atomic.AddUint64(&TotalMessages, 1)
-			break
-		case <-stop:
-			return
 		}
 	}
+	go func() {
+		var disconnected time.Time
+		subscribed := func() {
+			if disconnected.IsZero() {
+				RecordSubscribeLatency(time.Since(start))
+				return
+			}
+			if sentinelEnabled() {
+				recordReconnect(time.Since(disconnected))
+			}
+			CountDelivery("subscriber-reconnects")
+			disconnected = time.Time{}
+		}
+		// reconnect retries until a new receiver is created, returning nil once stopped
+		reconnect := func() pubsubReceiver {
+			for {
+				select {
+				case <-stop:
+					return nil
+				case <-time.After(100 * time.Millisecond):
+				}
+				target, targetRole := addr, role
+				if sentinelEnabled() {
+					target = reconnectTarget(addr, role)
+				}
+				if live != nil {
+					if target, targetRole = placeLiveSubscriber(live); target == "" {
+						continue
+					}
+				}
+				next, err := newPubsubReceiver(clientLibrary, target, credentials, subscriberName, channel)
+				if err != nil {
+					continue
+				}
+				if live != nil && target != addr {
+					migration, migrationStart = noteSlotMigration(channel, addr, target), lastMessage
+					if migrationStart.IsZero() {
+						migrationStart = disconnected
+					}
+					moveLiveSubscriber(live, target, targetRole)
+				}
+				addr, role = target, targetRole
+				return next
+			}
+		}
+		current := receiver
+		for {
+			err := current.Receive(channel, sharded, subscribed, onMessage)
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// subscribers reconnect when following failovers or migrations, when the fault proxy reset their connection,
+			// and after the chaos actions
+			if !sentinelEnabled() && live == nil && !faultProxyEnabled() && !chaosActive() {
+				if err != nil {
+					log.Printf("Subscriber %s stopped receiving messages from channel %s: %v", subscriberName, channel, err)
+				}
+				return
+			}
+			if disconnected.IsZero() {
+				disconnected = time.Now()
+			}
+			next := reconnect()
+			if next == nil {
+				return
+			}
+			receiverMutex.Lock()
+			select {
+			case <-stop:
+				receiverMutex.Unlock()
+				next.Close()
+				return
+			default:
+			}
+			current.Close()
+			receiver, current = next, next
+			receiverMutex.Unlock()
+		}
+	}()
+	<-stop
 }
 
-func BootstrapPubSub(addr string, subscriberName string, channel string) (rueidis.Client, error) {
+func BootstrapPubSub(addr string, credentials RedisCredentials, subscriberName string, channel string) (rueidis.Client, error) {
 	// Create a normal redis connection
-
-	c, err := rueidis.NewClient(rueidis.ClientOption{
-		InitAddress: []string{addr},
-	})
+	option := rueidisClientOption([]string{addr})
+	option.Username, option.Password = credentials.Username, credentials.Password
+	c, err := rueidis.NewClient(option)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -61,33 +170,27 @@ func BootstrapPubSub(addr string, subscriberName string, channel string) (rueidi
 	return c, err
 }
 
-func RedisPubSubLogic(debug int, stopChan chan struct{}, wg *sync.WaitGroup, distributeSubscribers bool, host string, port string, client_output_buffer_limit_pubsub string, channel_maximum int, channel_minimum int, subscribers_per_channel int, subscribers_placement string, subscribe_prefix string) {
-	nodes, node_subscriptions_count := getNodesInfo(distributeSubscribers, host, port)
+func RedisPubSubLogic(debug int, stopChan chan struct{}, wg *sync.WaitGroup, distributeSubscribers bool, host string, port string, client_output_buffer_limit_pubsub string, channel_maximum int, channel_minimum int, subscribers_per_channel int, subscribers_placement string, subscribe_prefix string, client_library string, subscribe_on string, data_size int, publish_rate int) {
+	placement := newSubscriberPlacement(distributeSubscribers, host, port, subscribe_on)
 	printMessages := false
 	if debug >= 2 {
 		printMessages = true
 	}
 	if strings.Compare(subscribers_placement, "dense") == 0 {
-		for channel_id := channel_minimum; channel_id <= channel_maximum; channel_id++ {
-			for channel_subscriber_number := 1; channel_subscriber_number <= subscribers_per_channel; channel_subscriber_number++ {
-				nodes_pos := channel_id % len(nodes)
-				node_subscriptions_count[nodes_pos]++
-				addr := nodes[nodes_pos]
-
-				channel := fmt.Sprintf("%s%d", subscribe_prefix, channel_id)
-				subscriberName := fmt.Sprintf("subscriber#%d-%s%d", channel_subscriber_number, subscribe_prefix, channel_id)
-				if debug >= 1 {
-					log.Printf("Channel %s subcriber #%d using node=%d (%s)", channel, channel_subscriber_number, nodes_pos, addr)
-				}
-				wg.Add(1)
-				go SubscriberRoutine(addr, subscriberName, channel, printMessages, stopChan, wg)
+		startSubscribers(stopChan, wg, channel_maximum, channel_minimum, subscribers_per_channel, func(channel_id int, channel_subscriber_number int, stop chan struct{}) {
+			channel := fmt.Sprintf("%s%d", subscribe_prefix, channel_id)
+			addr, role := placement.pick(channel, channel_id, false)
+			subscriberName := fmt.Sprintf("subscriber#%d-%s%d", channel_subscriber_number, subscribe_prefix, channel_id)
+			if debug >= 1 {
+				log.Printf("Channel %s subcriber #%d using node %s (%s)", channel, channel_subscriber_number, addr, role)
 			}
-		}
+			wg.Add(1)
+			go SubscriberRoutine(client_library, addr, role, subscriberCredentials(channel_id, addr), subscriberName, channel, printMessages, stop, wg)
+		})
 	}
+	startRedisPublishers(placement.primaries(), false, stopChan, wg, channel_maximum, channel_minimum, subscribe_prefix, data_size, publish_rate)
 	if debug >= 1 {
-		for nodes_pos, count := range node_subscriptions_count {
-			log.Printf("Node %s total subscriptions=%d", nodes[nodes_pos], count)
-		}
+		placement.logCounts()
 	}
 }
 
@@ -0,0 +1,53 @@
+package subscribe
+
+import (
+	"fmt"
+	"log"
+)
+
+// ResetServerState flushes the data of every primary and resets the statistics and frees the memory of every node, so
+// that each run starts from the same server state. The nodes come from the cluster topology, or from the --host
+// endpoints when it is not a cluster.
+func ResetServerState(host string, port string) error {
+	primaries := map[string]bool{}
+	nodes := []string{}
+	if topology, err := DiscoverTopologyFromArgs(host, port); err == nil {
+		for _, node := range topology.Nodes() {
+			primaries[node.Addr] = node.Role == NodeRolePrimary
+			nodes = append(nodes, node.Addr)
+		}
+	} else {
+		if nodes, _, err = getClusterNodesFromArgs(port, host); err != nil {
+			return err
+		}
+		for _, addr := range nodes {
+			primaries[addr] = true
+		}
+	}
+	for _, addr := range nodes {
+		commands := [][]string{{"CONFIG", "RESETSTAT"}, {"MEMORY", "PURGE"}}
+		if primaries[addr] {
+			commands = append([][]string{{"FLUSHALL"}}, commands...)
+		}
+		if err := resetNode(addr, commands); err != nil {
+			return err
+		}
+	}
+	log.Printf("Reset the state of %d nodes", len(nodes))
+	return nil
+}
+
+func resetNode(addr string, commands [][]string) error {
+	conn, err := dialResp(addr)
+	if err != nil {
+		return fmt.Errorf("unable to reset the state of %s: %v", addr, err)
+	}
+	defer conn.Close()
+	for _, args := range commands {
+		// MEMORY PURGE is only supported with jemalloc
+		if _, err := conn.Do(args...); err != nil && args[0] != "MEMORY" {
+			return fmt.Errorf("unable to reset the state of %s: %v", addr, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package subscribe
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// respConn is a minimal RESP2 connection pinned to a single node.
+// Contrary to rueidis.Client it never follows cluster redirections, which is
+// what we need when every node has to be configured or subscribed to individually.
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+type respError string
+
+func (e respError) Error() string { return string(e) }
+
+// dialResp connects and authenticates to a single node, using the configured TLS settings and credentials.
+func dialResp(addr string) (*respConn, error) {
+	return dialRespAs(addr, credentialsFor(addr))
+}
+
+func dialRespAs(addr string, credentials RedisCredentials) (*respConn, error) {
+	return dialRespThrough(addr, addr, credentials)
+}
+
+// dialRespThrough connects to the node addr by dialing via ( e.g. its fault proxy ), with the settings of addr.
+func dialRespThrough(addr string, via string, credentials RedisCredentials) (*respConn, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	var err error
+	network := endpointFor(addr).Network
+	if config := tlsConfigFor(addr); config != nil {
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		conn, err = tls.DialWithDialer(dialer, network, via, config)
+	} else {
+		conn, err = dialer.Dial(network, via)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &respConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	if auth := respAuthArgs(credentials); auth != nil {
+		if _, err = c.Do(auth...); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("%s: %v", addr, err)
+		}
+	}
+	return c, nil
+}
+
+// Do sends a single command and waits for its reply.
+func (c *respConn) Do(args ...string) (interface{}, error) {
+	if err := c.Send(args...); err != nil {
+		return nil, err
+	}
+	reply, err := c.ReadReply()
+	if err != nil {
+		return nil, err
+	}
+	if rerr, ok := reply.(respError); ok {
+		return nil, rerr
+	}
+	return reply, nil
+}
+
+// Send writes a command to the connection without waiting for the reply.
+func (c *respConn) Send(args ...string) error {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return c.w.Flush()
+}
+
+// ReadReply reads a single RESP2 reply. Errors sent by the server are returned as respError values.
+func (c *respConn) ReadReply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("resp: malformed reply line")
+	}
+	payload := line[1 : len(line)-2]
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return respError(payload), nil
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(payload)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		array := make([]interface{}, size)
+		for i := range array {
+			if array[i], err = c.ReadReply(); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	}
+	return nil, fmt.Errorf("resp: unexpected reply type %q", line[0])
+}
+
+func (c *respConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,70 @@
+package subscribe
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRespConnReadReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    interface{}
+		wantErr bool
+	}{
+		{"simple string", "+OK\r\n", "OK", false},
+		{"error", "-ERR unknown command\r\n", respError("ERR unknown command"), false},
+		{"integer", ":42\r\n", int64(42), false},
+		{"negative integer", ":-1\r\n", int64(-1), false},
+		{"bulk string", "$5\r\nhello\r\n", "hello", false},
+		{"bulk string with crlf", "$4\r\na\r\nb\r\n", "a\r\nb", false},
+		{"empty bulk string", "$0\r\n\r\n", "", false},
+		{"null bulk string", "$-1\r\n", nil, false},
+		{"array", "*2\r\n$3\r\nfoo\r\n:1\r\n", []interface{}{"foo", int64(1)}, false},
+		{"nested array", "*2\r\n*1\r\n+a\r\n*0\r\n", []interface{}{[]interface{}{"a"}, []interface{}{}}, false},
+		{"null array", "*-1\r\n", nil, false},
+		{"missing cr", "+OK\n", nil, true},
+		{"unknown type", "!3\r\n", nil, true},
+		{"invalid integer", ":abc\r\n", int64(0), true},
+		{"truncated bulk string", "$10\r\nhello\r\n", nil, true},
+		{"truncated array", "*2\r\n+a\r\n", nil, true},
+		{"empty input", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &respConn{r: bufio.NewReader(strings.NewReader(tt.input))}
+			got, err := c.ReadReply()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadReply(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadReply(%q) = %#v, want %#v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRespConnDo(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c := &respConn{conn: client, r: bufio.NewReader(client), w: bufio.NewWriter(client)}
+	received := make(chan string, 1)
+	go func() {
+		want := "*2\r\n$4\r\nECHO\r\n$2\r\nhi\r\n"
+		buf := make([]byte, len(want))
+		n, _ := server.Read(buf)
+		received <- string(buf[:n])
+		server.Write([]byte("-ERR boom\r\n"))
+	}()
+	_, err := c.Do("ECHO", "hi")
+	if got := <-received; got != "*2\r\n$4\r\nECHO\r\n$2\r\nhi\r\n" {
+		t.Errorf("sent %q", got)
+	}
+	if _, ok := err.(respError); !ok || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Do returned %v, want the server error", err)
+	}
+}
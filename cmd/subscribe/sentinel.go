@@ -0,0 +1,346 @@
+package subscribe
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/rueian/rueidis"
+)
+
+// sentinels are the --sentinel endpoints, empty when the primary is not resolved through Sentinel.
+var sentinels []Endpoint
+var sentinelMaster string
+
+// FailoverEvent is a primary switch announced by Sentinel during the run.
+type FailoverEvent struct {
+	// Time is the unix time, in milliseconds, of the +switch-master event
+	Time int64 `json:"Time"`
+	// Duration is the time, in seconds, between the first failure event (+sdown, +odown or +try-failover) and the switch
+	Duration   float64 `json:"Duration"`
+	OldPrimary string  `json:"OldPrimary"`
+	NewPrimary string  `json:"NewPrimary"`
+}
+
+// SentinelSummary describes the failovers that happened during the run and their impact on the subscribers.
+type SentinelSummary struct {
+	Master             string          `json:"Master"`
+	Failovers          []FailoverEvent `json:"Failovers"`
+	Reconnects         int64           `json:"Reconnects"`
+	ReconnectTimeP50   float64         `json:"ReconnectTimeP50"`
+	ReconnectTimeMax   float64         `json:"ReconnectTimeMax"`
+	MessagesPublished  uint64          `json:"MessagesPublished"`
+	MessagesLost       int64           `json:"MessagesLost"`
+	MessagesDuplicated int64           `json:"MessagesDuplicated"`
+}
+
+var failovers []FailoverEvent
+var failoverStart time.Time
+
+// reconnectHistogram holds the time (in microseconds) subscribers took to subscribe again after being disconnected by a failover.
+var reconnectHistogram = hdrhistogram.New(1, 10*60*1000*1000, 3)
+var failoverMutex sync.Mutex
+
+// SetSentinel parses the --sentinel endpoints and resolves the current primary of the master set.
+func SetSentinel(sentinel string, master string) (primary string, err error) {
+	if sentinels, err = ParseEndpoints(sentinel, "26379"); err != nil {
+		return "", fmt.Errorf("invalid --sentinel: %v", err)
+	}
+	sentinelMaster = master
+	if primary, err = sentinelPrimary(); err != nil {
+		sentinels = nil
+		return "", err
+	}
+	return primary, nil
+}
+
+func sentinelEnabled() bool {
+	return len(sentinels) > 0
+}
+
+// dialSentinel connects to a sentinel, using the credentials of its URI only: sentinels rarely share the redis ones.
+func dialSentinel(sentinel Endpoint) (*respConn, error) {
+	credentials := RedisCredentials{}
+	if sentinel.Credentials != nil {
+		credentials = *sentinel.Credentials
+	}
+	return dialRespAs(sentinel.Addr, credentials)
+}
+
+// sentinelDo runs a command on the first sentinel that replies.
+func sentinelDo(args ...string) (reply interface{}, err error) {
+	for _, sentinel := range sentinels {
+		var conn *respConn
+		if conn, err = dialSentinel(sentinel); err != nil {
+			continue
+		}
+		reply, err = conn.Do(args...)
+		conn.Close()
+		if err == nil {
+			return
+		}
+	}
+	return nil, fmt.Errorf("no sentinel replied to %s: %v", strings.Join(args, " "), err)
+}
+
+// sentinelPrimary returns the reachable address of the current primary.
+func sentinelPrimary() (string, error) {
+	reply, err := sentinelDo("SENTINEL", "GET-MASTER-ADDR-BY-NAME", sentinelMaster)
+	if err != nil {
+		return "", err
+	}
+	addr, ok := reply.([]interface{})
+	if !ok || len(addr) != 2 {
+		return "", fmt.Errorf("sentinel does not monitor the master set %s", sentinelMaster)
+	}
+	host, _ := addr[0].(string)
+	port, _ := addr[1].(string)
+	return mapAddress(net.JoinHostPort(host, port)), nil
+}
+
+// discoverSentinelTopology describes the master set as a single shard owning every slot, with its healthy replicas.
+func discoverSentinelTopology() (*ClusterTopology, error) {
+	primary, err := sentinelPrimary()
+	if err != nil {
+		return nil, err
+	}
+	topology := &ClusterTopology{Source: "SENTINEL REPLICAS", Shards: []ClusterShard{{
+		Slots:    [][2]int64{{0, 16383}},
+		Primary:  ClusterNode{Addr: primary, Role: NodeRolePrimary, Health: "online"},
+		Replicas: []ClusterNode{},
+	}}}
+	reply, err := sentinelDo("SENTINEL", "REPLICAS", sentinelMaster)
+	if err != nil {
+		// SENTINEL REPLICAS was added in redis 5.0
+		topology.Source = "SENTINEL SLAVES"
+		if reply, err = sentinelDo("SENTINEL", "SLAVES", sentinelMaster); err != nil {
+			return nil, err
+		}
+	}
+	replicas, _ := reply.([]interface{})
+	for _, replicaReply := range replicas {
+		replica := respMap(replicaReply)
+		flags, _ := replica["flags"].(string)
+		if strings.Contains(flags, "down") || strings.Contains(flags, "disconnected") {
+			continue
+		}
+		host, _ := replica["ip"].(string)
+		port, _ := replica["port"].(string)
+		id, _ := replica["runid"].(string)
+		topology.Shards[0].Replicas = append(topology.Shards[0].Replicas, ClusterNode{Id: id, Addr: net.JoinHostPort(host, port), Role: NodeRoleReplica, Health: "online"})
+	}
+	for i := range topology.Shards[0].Replicas {
+		mapNode(&topology.Shards[0].Replicas[i])
+	}
+	recordAddress(primary, "")
+	return topology, nil
+}
+
+// newSentinelPublishFunc publishes to the current primary, following the failovers.
+func newSentinelPublishFunc() (publishFunc, func()) {
+	ctx := context.Background()
+	addrs := []string{}
+	for _, sentinel := range sentinels {
+		addrs = append(addrs, sentinel.Addr)
+	}
+	// rueidis connects to the addresses announced by sentinel
+	if addressMapActive() {
+		credentials := credentialsFor("")
+		client := goredis.NewFailoverClient(&goredis.FailoverOptions{
+			MasterName:    sentinelMaster,
+			SentinelAddrs: addrs,
+			Username:      credentials.Username,
+			Password:      credentials.Password,
+			Dialer:        goRedisClusterDial,
+		})
+		return func(channel string, sharded bool, message string) error {
+			if sharded {
+				return client.SPublish(ctx, channel, message).Err()
+			}
+			return client.Publish(ctx, channel, message).Err()
+		}, func() { client.Close() }
+	}
+	option := rueidisClientOption(addrs)
+	credentials := credentialsFor("")
+	option.Username, option.Password = credentials.Username, credentials.Password
+	option.Sentinel = rueidis.SentinelOption{MasterSet: sentinelMaster}
+	if sentinels[0].Credentials != nil {
+		option.Sentinel.Username, option.Sentinel.Password = sentinels[0].Credentials.Username, sentinels[0].Credentials.Password
+	}
+	client, err := rueidis.NewClient(option)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return func(channel string, sharded bool, message string) error {
+		if sharded {
+			return client.Do(ctx, client.B().Spublish().Channel(channel).Message(message).Build()).Error()
+		}
+		return client.Do(ctx, client.B().Publish().Channel(channel).Message(message).Build()).Error()
+	}, client.Close
+}
+
+// SentinelWatcherRoutine follows the failover events of the master set, reconnecting to the next sentinel when needed.
+func SentinelWatcherRoutine(debug int, stop chan struct{}, wg *sync.WaitGroup) {
+	// tell the caller we've stopped
+	defer wg.Done()
+
+	for pos := 0; ; pos = (pos + 1) % len(sentinels) {
+		conn, err := dialSentinel(sentinels[pos])
+		if err == nil {
+			err = conn.Send("SUBSCRIBE", "+sdown", "+odown", "+try-failover", "+switch-master")
+		}
+		if err != nil {
+			log.Printf("Unable to follow the failovers using sentinel %s: %v", sentinels[pos].Addr, err)
+		} else {
+			done := make(chan struct{})
+			go func() {
+				select {
+				case <-stop:
+				case <-done:
+				}
+				conn.Close()
+			}()
+			for {
+				reply, err := conn.ReadReply()
+				if err != nil {
+					break
+				}
+				msg, ok := reply.([]interface{})
+				if !ok || len(msg) != 3 || msg[0] != "message" {
+					continue
+				}
+				event, _ := msg[1].(string)
+				payload, _ := msg[2].(string)
+				if debug >= 1 {
+					log.Printf("Sentinel %s event %s %s", sentinels[pos].Addr, event, payload)
+				}
+				onSentinelEvent(event, payload)
+			}
+			close(done)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func onSentinelEvent(event string, payload string) {
+	fields := strings.Fields(payload)
+	failoverMutex.Lock()
+	defer failoverMutex.Unlock()
+	switch event {
+	case "+sdown", "+odown", "+try-failover":
+		// <instance-type> <name> <ip> <port>
+		if len(fields) >= 2 && fields[0] == "master" && fields[1] == sentinelMaster && failoverStart.IsZero() {
+			failoverStart = time.Now()
+		}
+	case "+switch-master":
+		// <master name> <oldip> <oldport> <newip> <newport>
+		if len(fields) != 5 || fields[0] != sentinelMaster {
+			return
+		}
+		failover := FailoverEvent{
+			Time:       time.Now().UnixNano() / int64(time.Millisecond),
+			OldPrimary: mapAddress(net.JoinHostPort(fields[1], fields[2])),
+			NewPrimary: mapAddress(net.JoinHostPort(fields[3], fields[4])),
+		}
+		if !failoverStart.IsZero() {
+			failover.Duration = time.Since(failoverStart).Seconds()
+		}
+		log.Printf("Sentinel failover of %s from %s to %s", sentinelMaster, failover.OldPrimary, failover.NewPrimary)
+		failovers = append(failovers, failover)
+		failoverStart = time.Time{}
+	}
+}
+
+// reconnectTarget returns the node a dropped subscriber reconnects to: subscribers of the primary follow
+// the failover to the current primary, the others reconnect to the same node.
+func reconnectTarget(addr string, role string) string {
+	if role == NodeRoleReplica {
+		return addr
+	}
+	if primary, err := sentinelPrimary(); err == nil {
+		return primary
+	}
+	return addr
+}
+
+func recordReconnect(duration time.Duration) {
+	us := duration.Microseconds()
+	if us < 1 {
+		us = 1
+	}
+	failoverMutex.Lock()
+	reconnectHistogram.RecordValue(us)
+	failoverMutex.Unlock()
+}
+
+func resetFailovers() {
+	failoverMutex.Lock()
+	defer failoverMutex.Unlock()
+	failovers = nil
+	failoverStart = time.Time{}
+	reconnectHistogram.Reset()
+}
+
+// LostMessages and DuplicatedMessages are the gaps and repeats in the sequence of the built-in publishers messages,
+// summed over every subscriber.
+var LostMessages int64
+var DuplicatedMessages int64
+
+// sequenceTracker follows the sequence numbers of the messages a subscriber receives from a channel. Pub/sub delivers
+// the messages of a channel in order, so a gap is a lost message. Messages still in flight at the end of the run
+// are not gaps, and the messages published before the subscription are not expected.
+type sequenceTracker struct {
+	last    uint64
+	started bool
+}
+
+func (t *sequenceTracker) observe(message string) {
+	sequence, ok := payloadSequence(message)
+	if !ok {
+		return
+	}
+	switch {
+	case !t.started:
+		t.started = true
+		t.last = sequence
+	case sequence <= t.last:
+		atomic.AddInt64(&DuplicatedMessages, 1)
+	default:
+		atomic.AddInt64(&LostMessages, int64(sequence-t.last-1))
+		t.last = sequence
+	}
+}
+
+// SentinelResult returns the failover summary of the run, or nil when Sentinel is not used. The messages lost
+// and duplicated are the gaps and repeats in the sequence of the built-in publishers messages each subscriber received.
+func SentinelResult() *SentinelSummary {
+	if !sentinelEnabled() {
+		return nil
+	}
+	failoverMutex.Lock()
+	defer failoverMutex.Unlock()
+	summary := &SentinelSummary{
+		Master:             sentinelMaster,
+		Failovers:          append([]FailoverEvent{}, failovers...),
+		Reconnects:         reconnectHistogram.TotalCount(),
+		MessagesPublished:  atomic.LoadUint64(&PublishedMessages),
+		MessagesLost:       atomic.LoadInt64(&LostMessages),
+		MessagesDuplicated: atomic.LoadInt64(&DuplicatedMessages),
+	}
+	if summary.Reconnects > 0 {
+		summary.ReconnectTimeP50 = float64(reconnectHistogram.ValueAtQuantile(50.0)) / 1000.0
+		summary.ReconnectTimeMax = float64(reconnectHistogram.Max()) / 1000.0
+	}
+	return summary
+}
@@ -0,0 +1,68 @@
+package subscribe
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestSequencedPayload(t *testing.T) {
+	tests := []struct {
+		message      string
+		wantSequence uint64
+		wantOk       bool
+	}{
+		{newSequencedPayload(128, 42), 42, true},
+		{newSequencedPayload(0, 1), 1, true},
+		{newPayload(128), 0, false},
+		{newPayload(0), 0, false},
+		{"1 2", 0, false},
+		{"1 a b", 0, false},
+		{"external message", 0, false},
+	}
+	for _, tt := range tests {
+		sequence, ok := payloadSequence(tt.message)
+		if sequence != tt.wantSequence || ok != tt.wantOk {
+			t.Errorf("payloadSequence(%.20q) = %d, %v, want %d, %v", tt.message, sequence, ok, tt.wantSequence, tt.wantOk)
+		}
+	}
+	if message := newSequencedPayload(128, 7); len(message) != 128 {
+		t.Errorf("sequenced payload size %d, want 128", len(message))
+	}
+	if _, ok := payloadLatency(newSequencedPayload(128, 7)); !ok {
+		t.Errorf("sequenced payloads must carry the publish time")
+	}
+}
+
+func TestSequenceTracker(t *testing.T) {
+	tests := []struct {
+		name           string
+		sequences      []uint64
+		wantLost       int64
+		wantDuplicated int64
+	}{
+		{"in order", []uint64{1, 2, 3, 4}, 0, 0},
+		{"subscribed late", []uint64{10, 11, 12}, 0, 0},
+		{"gap", []uint64{1, 2, 5, 6}, 2, 0},
+		{"several gaps", []uint64{3, 5, 9}, 4, 0},
+		{"duplicate", []uint64{1, 2, 2, 3}, 0, 1},
+		{"replayed after a reconnection", []uint64{1, 2, 3, 2, 3, 4}, 0, 2},
+		{"gap and duplicate", []uint64{1, 4, 4, 5}, 2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ResetMetrics()
+			defer ResetMetrics()
+			tracker := sequenceTracker{}
+			tracker.observe("external message")
+			for _, sequence := range tt.sequences {
+				tracker.observe(newSequencedPayload(64, sequence))
+			}
+			if lost := atomic.LoadInt64(&LostMessages); lost != tt.wantLost {
+				t.Errorf("lost %d, want %d", lost, tt.wantLost)
+			}
+			if duplicated := atomic.LoadInt64(&DuplicatedMessages); duplicated != tt.wantDuplicated {
+				t.Errorf("duplicated %d, want %d", duplicated, tt.wantDuplicated)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package subscribe
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runUntilMessages starts a system, waits for it to receive at least want messages and stops it.
+func runUntilMessages(t *testing.T, want uint64, start func(stopChan chan struct{}, wg *sync.WaitGroup)) uint64 {
+	t.Helper()
+	ResetMetrics()
+	defer ResetMetrics()
+	stopChan := make(chan struct{})
+	wg := sync.WaitGroup{}
+	start(stopChan, &wg)
+	deadline := time.Now().Add(10 * time.Second)
+	for atomic.LoadUint64(&TotalMessages) < want && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	got := atomic.LoadUint64(&TotalMessages)
+	_, p50, _, _ := LatencySummary()
+	close(stopChan)
+	wg.Wait()
+	if got < want {
+		t.Fatalf("received %d messages, want at least %d", got, want)
+	}
+	if p50 <= 0 {
+		t.Fatalf("no end-to-end latency recorded")
+	}
+	return got
+}
+
+// waitFor polls the condition for up to 10 seconds.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
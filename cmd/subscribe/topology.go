@@ -0,0 +1,256 @@
+package subscribe
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+)
+
+var NodeRolePrimary string = "primary"
+var NodeRoleReplica string = "replica"
+
+// ClusterNode is a single redis node, as announced by the cluster.
+type ClusterNode struct {
+	Id     string `json:"Id"`
+	Addr   string `json:"Addr"`
+	Role   string `json:"Role"`
+	Health string `json:"Health,omitempty"`
+	// AnnouncedAddr is the address announced by the cluster, when Addr was remapped
+	AnnouncedAddr string `json:"AnnouncedAddr,omitempty"`
+}
+
+// ClusterShard is a primary, its replicas and the slot ranges they own.
+type ClusterShard struct {
+	Slots    [][2]int64    `json:"Slots"`
+	Primary  ClusterNode   `json:"Primary"`
+	Replicas []ClusterNode `json:"Replicas"`
+}
+
+// ClusterTopology is the de-duplicated view of the cluster, and the command used to discover it.
+type ClusterTopology struct {
+	Source string         `json:"Source"`
+	Shards []ClusterShard `json:"Shards"`
+}
+
+// Primaries returns the address of each primary, once.
+func (t *ClusterTopology) Primaries() (addrs []string) {
+	for _, shard := range t.Shards {
+		addrs = append(addrs, shard.Primary.Addr)
+	}
+	return
+}
+
+// Nodes returns every node of the cluster, primaries first.
+func (t *ClusterTopology) Nodes() (nodes []ClusterNode) {
+	for _, shard := range t.Shards {
+		nodes = append(nodes, shard.Primary)
+	}
+	for _, shard := range t.Shards {
+		nodes = append(nodes, shard.Replicas...)
+	}
+	return
+}
+
+// ShardOfSlot returns the shard owning the slot, or nil if the slot is not covered.
+func (t *ClusterTopology) ShardOfSlot(slot int64) *ClusterShard {
+	for i := range t.Shards {
+		for _, slots := range t.Shards[i].Slots {
+			if slot >= slots[0] && slot <= slots[1] {
+				return &t.Shards[i]
+			}
+		}
+	}
+	return nil
+}
+
+// OnlineReplicas returns the replicas able to serve subscribers: CLUSTER SHARDS reports the replicas still loading
+// their data or marked as failing with a health other than online. CLUSTER SLOTS reports no health, so its replicas are
+// assumed online.
+func (s *ClusterShard) OnlineReplicas() (replicas []ClusterNode) {
+	for _, replica := range s.Replicas {
+		if replica.Health == "" || replica.Health == "online" {
+			replicas = append(replicas, replica)
+		}
+	}
+	return
+}
+
+// DiscoverTopology reads the cluster topology from the first reachable seed, using CLUSTER SHARDS
+// (redis >= 7.0) and falling back to CLUSTER SLOTS on older versions.
+func DiscoverTopology(seeds []string) (topology *ClusterTopology, err error) {
+	for _, seed := range seeds {
+		var conn *respConn
+		if conn, err = dialResp(seed); err != nil {
+			continue
+		}
+		topology, err = discoverTopologyFromNode(conn)
+		conn.Close()
+		if err == nil {
+			mapTopology(topology)
+			return
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("no seed node to discover the cluster topology from")
+	}
+	return nil, err
+}
+
+func discoverTopologyFromNode(conn *respConn) (*ClusterTopology, error) {
+	reply, err := conn.Do("CLUSTER", "SHARDS")
+	if err == nil {
+		return parseClusterShards(reply)
+	}
+	if _, isRedisError := err.(respError); !isRedisError {
+		return nil, err
+	}
+	reply, err = conn.Do("CLUSTER", "SLOTS")
+	if err != nil {
+		return nil, err
+	}
+	return parseClusterSlots(reply)
+}
+
+// respMap converts a flat [key, value, key, value, ...] reply into a map.
+func respMap(reply interface{}) map[string]interface{} {
+	values, _ := reply.([]interface{})
+	m := map[string]interface{}{}
+	for i := 0; i+1 < len(values); i += 2 {
+		if key, ok := values[i].(string); ok {
+			m[key] = values[i+1]
+		}
+	}
+	return m
+}
+
+func respInt(reply interface{}) (int64, error) {
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, fmt.Errorf("unexpected integer reply %v", reply)
+}
+
+func parseClusterShards(reply interface{}) (*ClusterTopology, error) {
+	shards, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected CLUSTER SHARDS reply %v", reply)
+	}
+	topology := &ClusterTopology{Source: "CLUSTER SHARDS"}
+	for _, shardReply := range shards {
+		fields := respMap(shardReply)
+		shard := ClusterShard{Replicas: []ClusterNode{}}
+		slots, _ := fields["slots"].([]interface{})
+		for i := 0; i+1 < len(slots); i += 2 {
+			start, err := respInt(slots[i])
+			if err != nil {
+				return nil, err
+			}
+			end, err := respInt(slots[i+1])
+			if err != nil {
+				return nil, err
+			}
+			shard.Slots = append(shard.Slots, [2]int64{start, end})
+		}
+		nodes, _ := fields["nodes"].([]interface{})
+		hasPrimary := false
+		for _, nodeReply := range nodes {
+			node := respMap(nodeReply)
+			host, _ := node["endpoint"].(string)
+			if host == "" || host == "?" {
+				host, _ = node["ip"].(string)
+			}
+			port, err := respInt(node["port"])
+			if err != nil {
+				// TLS only nodes only announce the tls-port
+				if port, err = respInt(node["tls-port"]); err != nil {
+					return nil, err
+				}
+			}
+			id, _ := node["id"].(string)
+			health, _ := node["health"].(string)
+			clusterNode := ClusterNode{Id: id, Addr: net.JoinHostPort(host, strconv.FormatInt(port, 10)), Role: NodeRoleReplica, Health: health}
+			if role, _ := node["role"].(string); role == "master" {
+				clusterNode.Role = NodeRolePrimary
+				shard.Primary = clusterNode
+				hasPrimary = true
+			} else {
+				shard.Replicas = append(shard.Replicas, clusterNode)
+			}
+		}
+		// shards without slots or without a primary (e.g. failed) can not serve subscribers
+		if hasPrimary && len(shard.Slots) > 0 {
+			topology.Shards = append(topology.Shards, shard)
+		}
+	}
+	topology.sort()
+	return topology, nil
+}
+
+func parseClusterSlots(reply interface{}) (*ClusterTopology, error) {
+	ranges, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected CLUSTER SLOTS reply %v", reply)
+	}
+	topology := &ClusterTopology{Source: "CLUSTER SLOTS"}
+	// a primary owning several slot ranges is announced once per range
+	byPrimary := map[string]int{}
+	for _, rangeReply := range ranges {
+		group, ok := rangeReply.([]interface{})
+		if !ok || len(group) < 3 {
+			return nil, fmt.Errorf("unexpected CLUSTER SLOTS range %v", rangeReply)
+		}
+		start, err := respInt(group[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := respInt(group[1])
+		if err != nil {
+			return nil, err
+		}
+		nodes := []ClusterNode{}
+		for pos, nodeReply := range group[2:] {
+			node, ok := nodeReply.([]interface{})
+			if !ok || len(node) < 2 {
+				return nil, fmt.Errorf("unexpected CLUSTER SLOTS node %v", nodeReply)
+			}
+			host, _ := node[0].(string)
+			port, err := respInt(node[1])
+			if err != nil {
+				return nil, err
+			}
+			id := ""
+			if len(node) > 2 {
+				id, _ = node[2].(string)
+			}
+			role := NodeRoleReplica
+			if pos == 0 {
+				role = NodeRolePrimary
+			}
+			nodes = append(nodes, ClusterNode{Id: id, Addr: net.JoinHostPort(host, strconv.FormatInt(port, 10)), Role: role})
+		}
+		key := nodes[0].Id
+		if key == "" {
+			key = nodes[0].Addr
+		}
+		if idx, found := byPrimary[key]; found {
+			topology.Shards[idx].Slots = append(topology.Shards[idx].Slots, [2]int64{start, end})
+			continue
+		}
+		byPrimary[key] = len(topology.Shards)
+		topology.Shards = append(topology.Shards, ClusterShard{Slots: [][2]int64{{start, end}}, Primary: nodes[0], Replicas: nodes[1:]})
+	}
+	topology.sort()
+	return topology, nil
+}
+
+// sort orders the shards by their first slot, so that the node positions are stable across runs.
+func (t *ClusterTopology) sort() {
+	for _, shard := range t.Shards {
+		sort.Slice(shard.Slots, func(i, j int) bool { return shard.Slots[i][0] < shard.Slots[j][0] })
+	}
+	sort.Slice(t.Shards, func(i, j int) bool { return t.Shards[i].Slots[0][0] < t.Shards[j].Slots[0][0] })
+}
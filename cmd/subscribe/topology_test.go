@@ -0,0 +1,187 @@
+package subscribe
+
+import (
+	"reflect"
+	"testing"
+)
+
+// shardsNode builds a CLUSTER SHARDS node entry.
+func shardsNode(fields ...interface{}) []interface{} {
+	return fields
+}
+
+func TestParseClusterShards(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   interface{}
+		want    []ClusterShard
+		wantErr bool
+	}{
+		{
+			name: "primary with replica, sorted by first slot",
+			reply: []interface{}{
+				[]interface{}{
+					"slots", []interface{}{int64(8192), int64(16383)},
+					"nodes", []interface{}{
+						shardsNode("id", "b", "port", int64(7001), "ip", "10.0.0.2", "endpoint", "10.0.0.2", "role", "master", "health", "online"),
+					},
+				},
+				[]interface{}{
+					"slots", []interface{}{int64(5000), int64(8191), int64(0), int64(99)},
+					"nodes", []interface{}{
+						shardsNode("id", "c", "port", int64(7002), "ip", "10.0.0.3", "endpoint", "?", "role", "replica", "health", "online"),
+						shardsNode("id", "a", "port", int64(7000), "ip", "10.0.0.1", "endpoint", "node-a", "role", "master", "health", "online"),
+					},
+				},
+			},
+			want: []ClusterShard{
+				{
+					Slots:    [][2]int64{{0, 99}, {5000, 8191}},
+					Primary:  ClusterNode{Id: "a", Addr: "node-a:7000", Role: NodeRolePrimary, Health: "online"},
+					Replicas: []ClusterNode{{Id: "c", Addr: "10.0.0.3:7002", Role: NodeRoleReplica, Health: "online"}},
+				},
+				{
+					Slots:    [][2]int64{{8192, 16383}},
+					Primary:  ClusterNode{Id: "b", Addr: "10.0.0.2:7001", Role: NodeRolePrimary, Health: "online"},
+					Replicas: []ClusterNode{},
+				},
+			},
+		},
+		{
+			name: "tls only node",
+			reply: []interface{}{
+				[]interface{}{
+					"slots", []interface{}{int64(0), int64(16383)},
+					"nodes", []interface{}{shardsNode("id", "a", "tls-port", int64(6380), "ip", "::1", "role", "master")},
+				},
+			},
+			want: []ClusterShard{
+				{Slots: [][2]int64{{0, 16383}}, Primary: ClusterNode{Id: "a", Addr: "[::1]:6380", Role: NodeRolePrimary}, Replicas: []ClusterNode{}},
+			},
+		},
+		{
+			name: "shards without slots or primary are skipped",
+			reply: []interface{}{
+				[]interface{}{"slots", []interface{}{}, "nodes", []interface{}{shardsNode("id", "a", "port", int64(7000), "ip", "h", "role", "master")}},
+				[]interface{}{"slots", []interface{}{int64(0), int64(16383)}, "nodes", []interface{}{shardsNode("id", "b", "port", int64(7001), "ip", "h", "role", "replica")}},
+			},
+			want: nil,
+		},
+		{"not an array", "ERR", nil, true},
+		{
+			name:    "node without port",
+			reply:   []interface{}{[]interface{}{"slots", []interface{}{int64(0), int64(1)}, "nodes", []interface{}{shardsNode("id", "a", "ip", "h", "role", "master")}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid slot",
+			reply:   []interface{}{[]interface{}{"slots", []interface{}{"x", int64(1)}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topology, err := parseClusterShards(tt.reply)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseClusterShards() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if topology.Source != "CLUSTER SHARDS" {
+				t.Errorf("Source = %q", topology.Source)
+			}
+			if !reflect.DeepEqual(topology.Shards, tt.want) {
+				t.Errorf("parseClusterShards() = %+v, want %+v", topology.Shards, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseClusterSlots(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   interface{}
+		want    []ClusterShard
+		wantErr bool
+	}{
+		{
+			name: "primary owning several ranges is merged",
+			reply: []interface{}{
+				[]interface{}{int64(8192), int64(16383), []interface{}{"10.0.0.2", int64(7001), "b"}},
+				[]interface{}{int64(100), int64(8191), []interface{}{"10.0.0.1", int64(7000), "a"}, []interface{}{"10.0.0.3", int64(7002), "c"}},
+				[]interface{}{int64(0), int64(99), []interface{}{"10.0.0.1", int64(7000), "a"}, []interface{}{"10.0.0.3", int64(7002), "c"}},
+			},
+			want: []ClusterShard{
+				{
+					Slots:    [][2]int64{{0, 99}, {100, 8191}},
+					Primary:  ClusterNode{Id: "a", Addr: "10.0.0.1:7000", Role: NodeRolePrimary},
+					Replicas: []ClusterNode{{Id: "c", Addr: "10.0.0.3:7002", Role: NodeRoleReplica}},
+				},
+				{
+					Slots:    [][2]int64{{8192, 16383}},
+					Primary:  ClusterNode{Id: "b", Addr: "10.0.0.2:7001", Role: NodeRolePrimary},
+					Replicas: []ClusterNode{},
+				},
+			},
+		},
+		{
+			name: "redis < 4 without node ids",
+			reply: []interface{}{
+				[]interface{}{int64(0), int64(16383), []interface{}{"127.0.0.1", int64(30001)}},
+			},
+			want: []ClusterShard{
+				{Slots: [][2]int64{{0, 16383}}, Primary: ClusterNode{Addr: "127.0.0.1:30001", Role: NodeRolePrimary}, Replicas: []ClusterNode{}},
+			},
+		},
+		{"not an array", int64(1), nil, true},
+		{"range without nodes", []interface{}{[]interface{}{int64(0), int64(1)}}, nil, true},
+		{"invalid node", []interface{}{[]interface{}{int64(0), int64(1), []interface{}{"h"}}}, nil, true},
+		{"invalid port", []interface{}{[]interface{}{int64(0), int64(1), []interface{}{"h", "port"}}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topology, err := parseClusterSlots(tt.reply)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseClusterSlots() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(topology.Shards, tt.want) {
+				t.Errorf("parseClusterSlots() = %+v, want %+v", topology.Shards, tt.want)
+			}
+		})
+	}
+}
+
+func TestClusterTopologyShardOfSlot(t *testing.T) {
+	topology := &ClusterTopology{Shards: []ClusterShard{
+		{Slots: [][2]int64{{0, 99}, {200, 299}}, Primary: ClusterNode{Addr: "a:1"}},
+		{Slots: [][2]int64{{100, 199}}, Primary: ClusterNode{Addr: "b:1"}},
+	}}
+	tests := []struct {
+		slot int64
+		want string
+	}{
+		{0, "a:1"}, {99, "a:1"}, {100, "b:1"}, {199, "b:1"}, {250, "a:1"}, {300, ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if shard := topology.ShardOfSlot(tt.slot); shard != nil {
+			got = shard.Primary.Addr
+		}
+		if got != tt.want {
+			t.Errorf("ShardOfSlot(%d) = %q, want %q", tt.slot, got, tt.want)
+		}
+	}
+}
+
+func TestDisableTopologyRefresh(t *testing.T) {
+	EnableTopologyRefresh("127.0.0.1", "6379")
+	liveTopology = &ClusterTopology{}
+	DisableTopologyRefresh()
+	if liveTopologyEnabled || liveTopology != nil || liveTopologyHost != "" {
+		t.Errorf("the topology refresh is still set up after DisableTopologyRefresh: enabled %v, topology %v, host %q", liveTopologyEnabled, liveTopology, liveTopologyHost)
+	}
+}
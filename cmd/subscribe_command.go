@@ -20,19 +20,28 @@ import (
 	"fmt"
 	"github.com/codeperfio/pubsub-bench/cmd/subscribe"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"text/tabwriter"
 	"time"
 )
 
 var redisPubSub string = "redis-pubsub"
 var redisShardedPubSub string = "redis-sharded-pubsub"
-var subscribeChoices []string = []string{redisPubSub, redisShardedPubSub}
+var redisKeyspaceNotifications string = "redis-keyspace-notifications"
+var redisStreams string = "redis-streams"
+var redisList string = "redis-list"
+var natsPubSub string = "nats"
+var mqttPubSub string = "mqtt"
+var postgresNotify string = "postgres-notify"
+var inMemory string = "inmemory"
+var subscribeChoices []string = []string{redisPubSub, redisShardedPubSub, redisKeyspaceNotifications, redisStreams, redisList, natsPubSub, mqttPubSub, postgresNotify, subscribe.GatewayWebsocket, subscribe.GatewaySSE, inMemory}
 
 // subscribeCmd represents the subscribe command
 var subscribeCmd = &cobra.Command{
@@ -55,39 +64,153 @@ func init() {
 	rootCmd.PersistentFlags().Int("messages", 0, "Number of total messages per subscriber per channel.")
 	rootCmd.PersistentFlags().Int("client-update-tick", 1, "client update tick.")
 	rootCmd.PersistentFlags().Int("test-time", 0, "Number of seconds to run the test, after receiving the first message.")
+	rootCmd.PersistentFlags().Duration("warmup", 0, "warm-up period starting with the first message. Its ticks are shown, marked as warm-up, and excluded from the reported duration, message rate, latencies and time series. --test-time and --messages start after it.")
 	rootCmd.PersistentFlags().Int("debug-level", 0, "debug level. 0 - no debug; 1 - info; 2 - verbose.")
+	rootCmd.PersistentFlags().Int("publish-rate", 0, "number of messages (or write commands) per second issued by the built-in publisher of each channel. 0 disables the built-in publishers.")
+	rootCmd.PersistentFlags().Int("data-size", 128, "payload size in bytes of the messages sent by the built-in publishers.")
+	rootCmd.PersistentFlags().Int("calibration-time", 0, "if set, run the inmemory system during this number of seconds before the test and record its results as the tool calibration baseline.")
 
 	// specific to redis
 	rootCmd.PersistentFlags().Bool("oss-cluster-api-distribute-subscribers", false, "read cluster slots and distribute subscribers among them.")
 	rootCmd.PersistentFlags().String("client-output-buffer-limit-pubsub", "", "Specify client output buffer limits for clients subscribed to at least one pubsub channel or pattern. If the value specified is different that the one present on the DB, this setting will apply.")
-	rootCmd.PersistentFlags().String("host", "127.0.0.1", "redis host.")
-	rootCmd.PersistentFlags().String("port", "6379", "redis port.")
+	rootCmd.PersistentFlags().String("host", "127.0.0.1", "comma separated redis endpoints. Each one can be a host, a host:port pair ([addr]:port for IPv6) or a redis://, rediss:// or unix:// URI.")
+	rootCmd.PersistentFlags().String("port", "6379", "redis port of the --host entries without one. Either a single port for every host, or a comma separated list with one port per host.")
 	rootCmd.PersistentFlags().String("subscribers-placement-per-channel", "dense", "(dense,sparse) dense - Place all subscribers to channel in a specific shard. sparse- spread the subscribers across as many shards possible, in a round-robin manner.")
+	rootCmd.PersistentFlags().String("redis-client", subscribe.RedisClientRueidis, fmt.Sprintf("client library used by the redis-pubsub and redis-sharded-pubsub subscribers. (choices %s)", strings.Join(subscribe.RedisClientChoices, ",")))
+	rootCmd.PersistentFlags().Int("topology-refresh-interval", 0, "if set, refresh the cluster topology every this number of seconds, and whenever a redis-sharded-pubsub subscriber receives SUNSUBSCRIBE or MOVED, moving the subscribers of migrated slots to their new owner. 0 disables the live topology refresh.")
+	rootCmd.PersistentFlags().String("subscribe-on", subscribe.SubscribeOnPrimary, fmt.Sprintf("node role the redis-pubsub and redis-sharded-pubsub subscribers connect to, using the cluster topology. (choices %s)", strings.Join(subscribe.SubscribeOnChoices, ",")))
+	rootCmd.PersistentFlags().Int("acl-users", 0, "if set, create this number of temporary ACL users restricted to the channels of their subscribers, and assign the redis-pubsub and redis-sharded-pubsub subscribers to them. The users are deleted at the end of the run.")
+	rootCmd.PersistentFlags().Int("acl-patterns-per-user", 10, "number of filler channel patterns in each ACL user allow-list, checked before the patterns granting access to the user channels.")
+	rootCmd.PersistentFlags().String("acl-pattern-type", subscribe.ACLPatternLiteral, fmt.Sprintf("complexity of the ACL channel patterns. (choices %s)", strings.Join(subscribe.ACLPatternChoices, ",")))
+	rootCmd.PersistentFlags().Int("acl-baseline-time", 10, "number of seconds of the unrestricted baseline run executed before the --acl-users run. 0 disables the baseline run.")
+	rootCmd.PersistentFlags().String("keyspace-events", "KEg$", "notify-keyspace-events value set on each node while using redis-keyspace-notifications. The previous value is restored at the end of the run.")
+	rootCmd.PersistentFlags().String("stream-group", "pubsub-bench", "consumer group used by the redis-streams subscribers (XREADGROUP + XACK). If empty, each subscriber is an independent XREAD reader (fan-out).")
+	rootCmd.PersistentFlags().Int64("stream-maxlen", 0, "approximate MAXLEN trimming applied on each XADD by the redis-streams built-in publishers. 0 disables trimming.")
+	rootCmd.PersistentFlags().String("list-pop-command", "brpop", "(brpop,blmove) blocking command used by the redis-list consumers. blmove moves each message to a {queue}:processing list and removes it after being processed.")
+	rootCmd.PersistentFlags().Float64("list-block-timeout", 1, "timeout in seconds of each blocking pop issued by the redis-list consumers.")
+
+	// specific to nats
+	rootCmd.PersistentFlags().String("nats-url", "nats://127.0.0.1:4222", "nats server url.")
+	rootCmd.PersistentFlags().String("nats-queue-group", "", "if set, subscribers join this queue group and each message is delivered to a single subscriber per subject.")
+	rootCmd.PersistentFlags().Bool("nats-embedded-server", false, "start an in-process nats server and ignore --nats-url.")
+
+	// specific to mqtt
+	rootCmd.PersistentFlags().String("mqtt-broker", "tcp://127.0.0.1:1883", "mqtt broker url.")
+	rootCmd.PersistentFlags().Int("mqtt-qos", 0, "(0,1,2) QoS level used both to subscribe and publish.")
+	rootCmd.PersistentFlags().Bool("mqtt-clean-session", true, "connect the subscribers with the clean session flag.")
+	rootCmd.PersistentFlags().Bool("mqtt-wildcard", false, "subscribe using the <topic>/# wildcard filter instead of the exact topic.")
+	rootCmd.PersistentFlags().Bool("mqtt-embedded-broker", false, "start an in-process mqtt broker listening on the --mqtt-broker address.")
+
+	// specific to postgres
+	rootCmd.PersistentFlags().String("postgres-url", "postgres://postgres@127.0.0.1:5432/postgres", "postgres connection url used by the LISTEN subscribers and NOTIFY publishers.")
+
+	// specific to websocket and sse gateways
+	rootCmd.PersistentFlags().String("gateway-url", "ws://127.0.0.1:8080/ws/{channel}", "url template each websocket/sse client connects to. {channel} is replaced by the channel name. If not set, the sse system uses http://127.0.0.1:8080/sse/{channel}.")
+	rootCmd.PersistentFlags().String("gateway-publish-url", "http://127.0.0.1:8080/publish/{channel}", "url template the built-in publishers POST each message to.")
+	rootCmd.PersistentFlags().String("gateway-embedded", "", "if set, start the built-in echo gateway listening on this address (e.g. 127.0.0.1:8080).")
 
 }
 
 type testResult struct {
-	StartTime             int64     `json:"StartTime"`
-	Duration              float64   `json:"Duration"`
-	MessageRate           float64   `json:"MessageRate"`
-	TotalMessages         uint64    `json:"TotalMessages"`
-	TotalSubscriptions    int       `json:"TotalSubscriptions"`
-	ChannelMin            int       `json:"ChannelMin"`
-	ChannelMax            int       `json:"ChannelMax"`
-	SubscribersPerChannel int       `json:"SubscribersPerChannel"`
-	MessagesPerChannel    int64     `json:"MessagesPerChannel"`
-	MessageRateTs         []float64 `json:"MessageRateTs"`
-	OSSDistributedSlots   bool      `json:"OSSDistributedSlots"`
-	Addresses             []string  `json:"Addresses"`
+	StartTime             int64                            `json:"StartTime"`
+	Duration              float64                          `json:"Duration"`
+	MessageRate           float64                          `json:"MessageRate"`
+	TotalMessages         uint64                           `json:"TotalMessages"`
+	TotalSubscriptions    int                              `json:"TotalSubscriptions"`
+	ChannelMin            int                              `json:"ChannelMin"`
+	ChannelMax            int                              `json:"ChannelMax"`
+	SubscribersPerChannel int                              `json:"SubscribersPerChannel"`
+	PublishRate           int                              `json:"PublishRate"`
+	DataSize              int                              `json:"DataSize"`
+	MessagesPerChannel    int64                            `json:"MessagesPerChannel"`
+	MessageRateTs         []float64                        `json:"MessageRateTs"`
+	Warmup                float64                          `json:"Warmup,omitempty"`
+	WarmupRateTs          []float64                        `json:"WarmupRateTs,omitempty"`
+	OSSDistributedSlots   bool                             `json:"OSSDistributedSlots"`
+	Addresses             []subscribe.NodeAddress          `json:"Addresses"`
+	LatencyMean           float64                          `json:"LatencyMean"`
+	LatencyP50            float64                          `json:"LatencyP50"`
+	LatencyP99            float64                          `json:"LatencyP99"`
+	LatencyMax            float64                          `json:"LatencyMax"`
+	BacklogTs             []int64                          `json:"BacklogTs"`
+	ConsumerIdleTime      float64                          `json:"ConsumerIdleTime"`
+	DeliveryStats         map[string]uint64                `json:"DeliveryStats,omitempty"`
+	Calibration           *calibrationResult               `json:"Calibration,omitempty"`
+	RedisClient           string                           `json:"RedisClient,omitempty"`
+	RoleStats             map[string]subscribe.RoleSummary `json:"RoleStats,omitempty"`
+	SubscribeLatencyP50   float64                          `json:"SubscribeLatencyP50,omitempty"`
+	SubscribeLatencyP99   float64                          `json:"SubscribeLatencyP99,omitempty"`
+	ACL                   *aclResult                       `json:"ACL,omitempty"`
+	Sentinel              *subscribe.SentinelSummary       `json:"Sentinel,omitempty"`
+	SlotMigrations        []subscribe.SlotMigration        `json:"SlotMigrations,omitempty"`
+	Chaos                 []subscribe.ChaosEvent           `json:"Chaos,omitempty"`
+}
+
+// aclResult describes the --acl-users run, and the unrestricted baseline run it should be compared with.
+type aclResult struct {
+	Users           int                   `json:"Users"`
+	PatternsPerUser int                   `json:"PatternsPerUser"`
+	PatternType     string                `json:"PatternType"`
+	Baseline        *subscribe.RunSummary `json:"Baseline,omitempty"`
+}
+
+// calibrationResult is the tool own maximum throughput and latency floor (measured at a low publish rate), using the inmemory system.
+type calibrationResult struct {
+	MessageRate float64 `json:"MessageRate"`
+	LatencyP50  float64 `json:"LatencyP50"`
+	LatencyP99  float64 `json:"LatencyP99"`
 }
 
 func subcribeLogic(cmd *cobra.Command, args []string) {
-	system, _ := cmd.Flags().GetString("system")
+	workload_file := viper.GetString("workload")
+	repetitions, _ := cmd.Flags().GetInt("repetitions")
+	if repetitions < 1 || (repetitions > 1 && workload_file != "") {
+		log.Fatal(fmt.Errorf("--repetitions must be at least 1, and is not supported with --workload"))
+	}
+	if workload_file != "" {
+		runWorkload(cmd, workload_file)
+		return
+	}
 	json_out_file, _ := cmd.Flags().GetString("json-out-file")
+	if repetitions > 1 {
+		runRepetitions(cmd, repetitions, json_out_file)
+		return
+	}
+	res, _ := runSubscribe(cmd, nil)
+	writeJSON(json_out_file, res)
+}
+
+// runControl lets a caller follow and end a run: started receives the time the measurements start from, once the
+// first message arrived and the warm-up ended, and closing done ends the run as if its test time elapsed. A deadline
+// ends the run that long after the subscribers were started, even if no message arrived.
+type runControl struct {
+	started  chan time.Time
+	done     chan struct{}
+	deadline time.Duration
+}
+
+func newRunControl() *runControl {
+	return &runControl{started: make(chan time.Time, 1), done: make(chan struct{})}
+}
+
+// runSubscribe runs the test described by the flags, printing its progress and summary. It returns false when
+// the run was interrupted with C-c.
+func runSubscribe(cmd *cobra.Command, control *runControl) (testResult, bool) {
+	applyRedisConnectionOptions()
+	// the sweep and the repetitions run several times in the same process: start without the fault proxy and the topology refresh of the previous run
+	subscribe.StopFaultProxies()
+	subscribe.DisableTopologyRefresh()
+	system, _ := cmd.Flags().GetString("system")
 	subscribe_prefix, _ := cmd.Flags().GetString("subscriber-prefix")
 	client_output_buffer_limit_pubsub, _ := cmd.Flags().GetString("client-output-buffer-limit-pubsub")
 	host, _ := cmd.Flags().GetString("host")
 	port, _ := cmd.Flags().GetString("port")
+	redis_client, _ := cmd.Flags().GetString("redis-client")
+	subscribe_on, _ := cmd.Flags().GetString("subscribe-on")
+	topology_refresh_interval, _ := cmd.Flags().GetInt("topology-refresh-interval")
+	fault_proxy, _ := cmd.Flags().GetBool("fault-proxy")
+	local_cluster, _ := cmd.Flags().GetInt("local-cluster")
+	reset_server, _ := cmd.Flags().GetBool("reset-server")
 	subscribers_placement, _ := cmd.Flags().GetString("subscribers-placement-per-channel")
 	debugLevel, _ := cmd.Flags().GetInt("debug-level")
 	distributeSubscribers, _ := cmd.Flags().GetBool("oss-cluster-api-distribute-subscribers")
@@ -97,90 +220,404 @@ func subcribeLogic(cmd *cobra.Command, args []string) {
 	messages_per_channel_subscriber, _ := cmd.Flags().GetInt("messages")
 	client_update_tick, _ := cmd.Flags().GetInt("client-update-tick")
 	test_time, _ := cmd.Flags().GetInt("test-time")
+	warmup, _ := cmd.Flags().GetDuration("warmup")
+	publish_rate, _ := cmd.Flags().GetInt("publish-rate")
+	keyspace_events, _ := cmd.Flags().GetString("keyspace-events")
+	data_size, _ := cmd.Flags().GetInt("data-size")
+	calibration_time, _ := cmd.Flags().GetInt("calibration-time")
+	acl_users, _ := cmd.Flags().GetInt("acl-users")
+	acl_patterns_per_user, _ := cmd.Flags().GetInt("acl-patterns-per-user")
+	acl_pattern_type, _ := cmd.Flags().GetString("acl-pattern-type")
+	acl_baseline_time, _ := cmd.Flags().GetInt("acl-baseline-time")
+	stream_group, _ := cmd.Flags().GetString("stream-group")
+	stream_maxlen, _ := cmd.Flags().GetInt64("stream-maxlen")
+	list_pop_command, _ := cmd.Flags().GetString("list-pop-command")
+	list_block_timeout, _ := cmd.Flags().GetFloat64("list-block-timeout")
+	nats_url, _ := cmd.Flags().GetString("nats-url")
+	nats_queue_group, _ := cmd.Flags().GetString("nats-queue-group")
+	nats_embedded_server, _ := cmd.Flags().GetBool("nats-embedded-server")
+	mqtt_broker, _ := cmd.Flags().GetString("mqtt-broker")
+	mqtt_qos, _ := cmd.Flags().GetInt("mqtt-qos")
+	mqtt_clean_session, _ := cmd.Flags().GetBool("mqtt-clean-session")
+	mqtt_wildcard, _ := cmd.Flags().GetBool("mqtt-wildcard")
+	mqtt_embedded_broker, _ := cmd.Flags().GetBool("mqtt-embedded-broker")
+	postgres_url, _ := cmd.Flags().GetString("postgres-url")
+	gateway_url, _ := cmd.Flags().GetString("gateway-url")
+	gateway_publish_url, _ := cmd.Flags().GetString("gateway-publish-url")
+	gateway_embedded, _ := cmd.Flags().GetString("gateway-embedded")
+	if system == subscribe.GatewaySSE && !cmd.Flags().Changed("gateway-url") {
+		gateway_url = "http://127.0.0.1:8080/sse/{channel}"
+	}
 
 	if test_time != 0 && messages_per_channel_subscriber != 0 {
 		log.Fatal(fmt.Errorf("--messages and --test-time are mutially exclusive ( please specify one or the other )"))
 	}
+	if warmup < 0 {
+		log.Fatal(fmt.Errorf("--warmup can not be negative"))
+	}
+	var localCluster *subscribe.LocalCluster
+	// log.Fatal skips the deferred functions: stop the local cluster first, so its nodes and temporary directory do not
+	// outlive the failed run
+	fatal := func(err error) {
+		if localCluster != nil {
+			localCluster.Stop()
+		}
+		log.Fatal(err)
+	}
+	if local_cluster > 0 {
+		switch system {
+		case redisPubSub, redisShardedPubSub, redisKeyspaceNotifications, redisStreams, redisList:
+		default:
+			log.Fatal(fmt.Errorf("--local-cluster is only supported by the redis systems"))
+		}
+		localCluster = startLocalCluster(cmd, local_cluster)
+		defer localCluster.Stop()
+		host, port = strings.Join(localCluster.Addresses(), ","), "6379"
+		distributeSubscribers = true
+		log.Printf("Using the local cluster %s", host)
+	}
+	result_redis_client := ""
+	if system == redisPubSub || system == redisShardedPubSub {
+		if !contains(subscribe.RedisClientChoices, redis_client) {
+			fatal(fmt.Errorf("unsupported --redis-client %s ( choices %s )", redis_client, strings.Join(subscribe.RedisClientChoices, ",")))
+		}
+		if !contains(subscribe.SubscribeOnChoices, subscribe_on) {
+			fatal(fmt.Errorf("unsupported --subscribe-on %s ( choices %s )", subscribe_on, strings.Join(subscribe.SubscribeOnChoices, ",")))
+		}
+		// cluster aware clients would redirect the subscribers to the primaries
+		if subscribe_on != subscribe.SubscribeOnPrimary && redis_client != subscribe.RedisClientResp {
+			log.Printf("--subscribe-on %s requires direct node connections. Using --redis-client %s instead of %s", subscribe_on, subscribe.RedisClientResp, redis_client)
+			redis_client = subscribe.RedisClientResp
+		}
+		result_redis_client = redis_client
+	}
+	switch system {
+	case redisPubSub, redisShardedPubSub, redisKeyspaceNotifications, redisStreams, redisList:
+		endpoints := applyRedisEndpoints(host, port, debugLevel)
+		// rueidis only dials tcp, and is used by the built-in publishers and the streams and lists consumers
+		unixSupported := publish_rate == 0 && (system == redisKeyspaceNotifications || ((system == redisPubSub || system == redisShardedPubSub) && redis_client != subscribe.RedisClientRueidis))
+		if hasUnixEndpoint(endpoints) && !unixSupported {
+			fatal(fmt.Errorf("unix socket endpoints are only supported by the redis-pubsub and redis-sharded-pubsub subscribers using --redis-client resp or go-redis, and by the redis-keyspace-notifications subscribers, without built-in publishers ( --publish-rate 0 )"))
+		}
+		// rueidis always connects to the addresses announced by the cluster
+		if addressMapEnabled() {
+			if system == redisStreams || system == redisList || (system == redisKeyspaceNotifications && publish_rate > 0) {
+				fatal(fmt.Errorf("--address-map-file and --address-map-rule are only supported by the redis-pubsub and redis-sharded-pubsub systems, and by the redis-keyspace-notifications subscribers without built-in publishers ( --publish-rate 0 )"))
+			}
+			if redis_client == subscribe.RedisClientRueidis && (system == redisPubSub || system == redisShardedPubSub) {
+				log.Printf("Remapped node addresses require direct node connections. Using --redis-client %s instead of %s", subscribe.RedisClientResp, redis_client)
+				redis_client = subscribe.RedisClientResp
+				result_redis_client = redis_client
+			}
+		}
+	}
+	if fault_proxy {
+		if system != redisPubSub && system != redisShardedPubSub {
+			fatal(fmt.Errorf("--fault-proxy is only supported by the %s and %s systems", redisPubSub, redisShardedPubSub))
+		}
+		if redis_client == subscribe.RedisClientRueidis {
+			log.Printf("The fault proxy requires direct node connections. Using --redis-client %s instead of %s", subscribe.RedisClientResp, redis_client)
+			redis_client = subscribe.RedisClientResp
+			result_redis_client = redis_client
+		}
+		subscribe.EnableFaultProxy(faultProfile(cmd))
+	}
+	sentinelEnabled := false
+	if sentinelConfigured() {
+		if system != redisPubSub && system != redisShardedPubSub {
+			fatal(fmt.Errorf("--sentinel is only supported by the %s and %s systems", redisPubSub, redisShardedPubSub))
+		}
+		// the primary and its replicas are placed using the topology reported by Sentinel
+		host, sentinelEnabled = applySentinel(host)
+		distributeSubscribers = true
+		// rueidis and go-redis reconnect on their own without reporting it, hiding the reconnect time
+		if redis_client != subscribe.RedisClientResp {
+			log.Printf("Following the Sentinel failovers requires direct node connections. Using --redis-client %s instead of %s", subscribe.RedisClientResp, redis_client)
+			redis_client = subscribe.RedisClientResp
+			result_redis_client = redis_client
+		}
+	}
+	if topology_refresh_interval > 0 {
+		if system != redisShardedPubSub {
+			fatal(fmt.Errorf("--topology-refresh-interval is only supported by the %s system", redisShardedPubSub))
+		}
+		if !distributeSubscribers {
+			fatal(fmt.Errorf("--topology-refresh-interval requires the cluster topology ( --oss-cluster-api-distribute-subscribers )"))
+		}
+		// rueidis and go-redis do not report the SUNSUBSCRIBE of a migrated slot
+		if redis_client != subscribe.RedisClientResp {
+			log.Printf("Following the slot migrations requires direct node connections. Using --redis-client %s instead of %s", subscribe.RedisClientResp, redis_client)
+			redis_client = subscribe.RedisClientResp
+			result_redis_client = redis_client
+		}
+		subscribe.EnableTopologyRefresh(host, port)
+	}
+	chaos := chaosActions()
+	if len(chaos) > 0 {
+		switch system {
+		case redisPubSub, redisShardedPubSub, redisKeyspaceNotifications, redisStreams, redisList:
+		default:
+			fatal(fmt.Errorf("--chaos is only supported by the redis systems"))
+		}
+	}
+	subscribe.SetChaosEnabled(len(chaos) > 0)
+	if reset_server {
+		switch system {
+		case redisPubSub, redisShardedPubSub, redisKeyspaceNotifications, redisStreams, redisList:
+		default:
+			fatal(fmt.Errorf("--reset-server is only supported by the redis systems"))
+		}
+		if err := subscribe.ResetServerState(host, port); err != nil {
+			fatal(err)
+		}
+	}
+	if acl_users > 0 {
+		if system != redisPubSub && system != redisShardedPubSub {
+			fatal(fmt.Errorf("--acl-users is only supported by the %s and %s systems", redisPubSub, redisShardedPubSub))
+		}
+		if !contains(subscribe.ACLPatternChoices, acl_pattern_type) {
+			fatal(fmt.Errorf("unsupported --acl-pattern-type %s ( choices %s )", acl_pattern_type, strings.Join(subscribe.ACLPatternChoices, ",")))
+		}
+	}
+	redisPubSubLogic := func(stopChan chan struct{}, wg *sync.WaitGroup) {
+		if system == redisShardedPubSub {
+			subscribe.RedisShardedPubSubLogic(debugLevel, stopChan, wg, distributeSubscribers, host, port, client_output_buffer_limit_pubsub, channel_maximum, channel_minimum, subscribers_per_channel, subscribers_placement, subscribe_prefix, redis_client, subscribe_on, data_size, publish_rate)
+		} else {
+			subscribe.RedisPubSubLogic(debugLevel, stopChan, wg, distributeSubscribers, host, port, client_output_buffer_limit_pubsub, channel_maximum, channel_minimum, subscribers_per_channel, subscribers_placement, subscribe_prefix, redis_client, subscribe_on, data_size, publish_rate)
+		}
+	}
 
 	total_channels := channel_maximum - channel_minimum + 1
 	total_subscriptions := total_channels * subscribers_per_channel
 	total_messages := int64(total_subscriptions * messages_per_channel_subscriber)
 	fmt.Println(fmt.Sprintf("Total subcriptions: %d. Total messages: %d", total_subscriptions, total_messages))
 
-	subscribe.TotalMessages = 0
+	var calibration *calibrationResult
+	if calibration_time > 0 {
+		log.Printf("Running the inmemory calibration baseline during %d seconds", calibration_time)
+		rate, p50, p99 := subscribe.Calibrate(time.Duration(calibration_time)*time.Second, channel_maximum, channel_minimum, subscribers_per_channel, data_size)
+		log.Printf("Calibration baseline: message rate %f, latency (ms) p50 %.3f p99 %.3f", rate, p50, p99)
+		calibration = &calibrationResult{MessageRate: rate, LatencyP50: p50, LatencyP99: p99}
+	}
+
+	var acl *aclResult
+	if acl_users > 0 {
+		acl = &aclResult{Users: acl_users, PatternsPerUser: acl_patterns_per_user, PatternType: acl_pattern_type}
+		if acl_baseline_time > 0 {
+			log.Printf("Running the unrestricted ACL baseline during %d seconds", acl_baseline_time)
+			baseline := subscribe.MeasureRun(time.Duration(acl_baseline_time)*time.Second, redisPubSubLogic)
+			log.Printf("ACL baseline: message rate %f, latency (ms) p50 %.3f p99 %.3f, subscribe latency (ms) p50 %.3f p99 %.3f", baseline.MessageRate, baseline.LatencyP50, baseline.LatencyP99, baseline.SubscribeLatencyP50, baseline.SubscribeLatencyP99)
+			acl.Baseline = &baseline
+		}
+		subscribe.CreateACLUsers(distributeSubscribers, host, port, acl_users, acl_patterns_per_user, acl_pattern_type, channel_maximum, channel_minimum, subscribe_prefix)
+	}
+
+	subscribe.ResetMetrics()
 
 	stopChan := make(chan struct{})
 	// a WaitGroup for the goroutines to tell us they've stopped
 	wg := sync.WaitGroup{}
 
 	switch system {
-	case redisPubSub:
+	case redisPubSub, redisShardedPubSub:
+		{
+			if sentinelEnabled {
+				wg.Add(1)
+				go subscribe.SentinelWatcherRoutine(debugLevel, stopChan, &wg)
+			}
+			if topology_refresh_interval > 0 {
+				wg.Add(1)
+				go subscribe.TopologyRefreshRoutine(time.Duration(topology_refresh_interval)*time.Second, stopChan, &wg)
+			}
+			redisPubSubLogic(stopChan, &wg)
+		}
+	case redisKeyspaceNotifications:
+		{
+			subscribe.RedisKeyspaceNotificationsLogic(debugLevel, stopChan, &wg, distributeSubscribers, host, port, keyspace_events, channel_maximum, channel_minimum, subscribers_per_channel, subscribe_prefix, publish_rate)
+		}
+	case redisStreams:
+		{
+			subscribe.RedisStreamsLogic(debugLevel, stopChan, &wg, distributeSubscribers, host, port, channel_maximum, channel_minimum, subscribers_per_channel, subscribe_prefix, stream_group, stream_maxlen, data_size, publish_rate)
+		}
+	case redisList:
+		{
+			subscribe.RedisListLogic(debugLevel, stopChan, &wg, distributeSubscribers, host, port, channel_maximum, channel_minimum, subscribers_per_channel, subscribe_prefix, list_pop_command, list_block_timeout, data_size, publish_rate)
+		}
+	case natsPubSub:
 		{
-			subscribe.RedisPubSubLogic(debugLevel, stopChan, &wg, distributeSubscribers, host, port, client_output_buffer_limit_pubsub, channel_maximum, channel_minimum, subscribers_per_channel, subscribers_placement, subscribe_prefix)
+			subscribe.NatsLogic(debugLevel, stopChan, &wg, nats_url, nats_embedded_server, nats_queue_group, channel_maximum, channel_minimum, subscribers_per_channel, subscribe_prefix, data_size, publish_rate)
 		}
-	case redisShardedPubSub:
+	case mqttPubSub:
 		{
-			subscribe.RedisShardedPubSubLogic(debugLevel, stopChan, &wg, distributeSubscribers, host, port, client_output_buffer_limit_pubsub, channel_maximum, channel_minimum, subscribers_per_channel, subscribers_placement, subscribe_prefix)
+			subscribe.MqttLogic(debugLevel, stopChan, &wg, mqtt_broker, mqtt_embedded_broker, mqtt_qos, mqtt_clean_session, mqtt_wildcard, channel_maximum, channel_minimum, subscribers_per_channel, subscribe_prefix, data_size, publish_rate)
 		}
+	case postgresNotify:
+		{
+			subscribe.PostgresNotifyLogic(debugLevel, stopChan, &wg, postgres_url, channel_maximum, channel_minimum, subscribers_per_channel, subscribe_prefix, data_size, publish_rate)
+		}
+	case inMemory:
+		{
+			subscribe.InMemoryLogic(debugLevel, stopChan, &wg, channel_maximum, channel_minimum, subscribers_per_channel, subscribe_prefix, data_size, publish_rate)
+		}
+	case subscribe.GatewayWebsocket, subscribe.GatewaySSE:
+		{
+			subscribe.GatewayLogic(debugLevel, stopChan, &wg, system, gateway_url, gateway_publish_url, gateway_embedded, channel_maximum, channel_minimum, subscribers_per_channel, subscribe_prefix, data_size, publish_rate)
+		}
+	}
+
+	if len(chaos) > 0 {
+		wg.Add(1)
+		go subscribe.ChaosRoutine(chaos, host, port, stopChan, &wg)
 	}
 
 	// listen for C-c
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
+	defer signal.Stop(c)
 	w := new(tabwriter.Writer)
 
 	tick := time.NewTicker(time.Duration(client_update_tick) * time.Second)
-	closed, start_time, duration, totalMessages, messageRateTs := updateCLI(tick, c, total_messages, w, test_time)
+	completed, start_time, duration, totalMessages, messageRateTs, backlogTs, warmupRateTs := updateCLI(tick, c, total_messages, w, test_time, warmup, control)
 	messageRate := float64(totalMessages) / float64(duration.Seconds())
+	latencyMean, latencyP50, latencyP99, latencyMax := subscribe.LatencySummary()
+	consumerIdleTime := time.Duration(atomic.LoadInt64(&subscribe.IdleTime)).Seconds()
+	roleStats := subscribe.RoleSummaries(duration)
+	subscribeLatencyP50, subscribeLatencyP99 := subscribe.SubscribeLatencySummary()
+	sentinel := subscribe.SentinelResult()
+	slotMigrations := subscribe.SlotMigrations()
+	// the chaos offsets start with the first message, warm-up included
+	chaosEvents := subscribe.ChaosResults(append(append([]float64{}, warmupRateTs...), messageRateTs...), time.Duration(client_update_tick)*time.Second, chaosRecoveryThreshold())
 
-	fmt.Fprint(w, fmt.Sprintf("#################################################\nTotal Duration %f Seconds\nMessage Rate %f\n#################################################\n", duration.Seconds(), messageRate))
-	fmt.Fprint(w, "\r\n")
-	w.Flush()
-
-	if strings.Compare(json_out_file, "") != 0 {
-
-		res := testResult{
-			StartTime:             start_time.Unix(),
-			Duration:              duration.Seconds(),
-			MessageRate:           messageRate,
-			TotalMessages:         totalMessages,
-			TotalSubscriptions:    total_subscriptions,
-			ChannelMin:            channel_minimum,
-			ChannelMax:            channel_maximum,
-			SubscribersPerChannel: subscribers_per_channel,
-			MessagesPerChannel:    int64(messages_per_channel_subscriber),
-			MessageRateTs:         messageRateTs,
+	fmt.Fprint(w, fmt.Sprintf("#################################################\nTotal Duration %f Seconds\nMessage Rate %f\n", duration.Seconds(), messageRate))
+	if len(warmupRateTs) > 0 {
+		fmt.Fprint(w, fmt.Sprintf("Warm-up of %s ( %d ticks ) excluded\n", warmup, len(warmupRateTs)))
+	}
+	if latencyMax > 0 {
+		fmt.Fprint(w, fmt.Sprintf("Latency (ms) mean %.3f p50 %.3f p99 %.3f max %.3f\n", latencyMean, latencyP50, latencyP99, latencyMax))
+	}
+	if consumerIdleTime > 0 {
+		fmt.Fprint(w, fmt.Sprintf("Consumer Idle Time %f Seconds\n", consumerIdleTime))
+	}
+	if subscribeLatencyP99 > 0 {
+		fmt.Fprint(w, fmt.Sprintf("Subscribe Latency (ms) p50 %.3f p99 %.3f\n", subscribeLatencyP50, subscribeLatencyP99))
+	}
+	if acl != nil && acl.Baseline != nil {
+		fmt.Fprint(w, fmt.Sprintf("Unrestricted ACL baseline: Message Rate %f Latency (ms) p50 %.3f p99 %.3f Subscribe Latency (ms) p50 %.3f p99 %.3f\n", acl.Baseline.MessageRate, acl.Baseline.LatencyP50, acl.Baseline.LatencyP99, acl.Baseline.SubscribeLatencyP50, acl.Baseline.SubscribeLatencyP99))
+	}
+	for role, stats := range roleStats {
+		fmt.Fprint(w, fmt.Sprintf("Subscribers on %s nodes: Message Rate %f Latency (ms) p50 %.3f p99 %.3f\n", role, stats.MessageRate, stats.LatencyP50, stats.LatencyP99))
+	}
+	if sentinel != nil {
+		for _, failover := range sentinel.Failovers {
+			fmt.Fprint(w, fmt.Sprintf("Sentinel failover from %s to %s in %.3f Seconds\n", failover.OldPrimary, failover.NewPrimary, failover.Duration))
 		}
-		file, err := json.MarshalIndent(res, "", " ")
-		if err != nil {
-			log.Fatal(err)
+		if sentinel.Reconnects > 0 {
+			fmt.Fprint(w, fmt.Sprintf("Subscriber reconnects %d: Reconnect Time (ms) p50 %.3f max %.3f\n", sentinel.Reconnects, sentinel.ReconnectTimeP50, sentinel.ReconnectTimeMax))
 		}
-
-		err = ioutil.WriteFile(json_out_file, file, 0644)
-		if err != nil {
-			log.Fatal(err)
+		if sentinel.MessagesPublished > 0 {
+			fmt.Fprint(w, fmt.Sprintf("Messages published %d, lost %d, duplicated %d\n", sentinel.MessagesPublished, sentinel.MessagesLost, sentinel.MessagesDuplicated))
+		}
+	}
+	for _, migration := range slotMigrations {
+		fmt.Fprint(w, fmt.Sprintf("Slot %d migrated from %s to %s: %d subscribers moved, Delivery Gap (ms) max %.3f\n", migration.Slot, migration.OldNode, migration.NewNode, migration.Subscribers, migration.DeliveryGapMax))
+	}
+	for _, event := range chaosEvents {
+		switch {
+		case event.Error != "":
+			fmt.Fprint(w, fmt.Sprintf("Chaos %q on %s at T+%.3fs failed: %s\n", event.Spec, event.Node, event.Offset, event.Error))
+		case event.RecoveryTime < 0:
+			fmt.Fprint(w, fmt.Sprintf("Chaos %q on %s at T+%.3fs: message rate did not recover to %.2f\n", event.Spec, event.Node, event.Offset, event.PreEventRate))
+		default:
+			fmt.Fprint(w, fmt.Sprintf("Chaos %q on %s at T+%.3fs: message rate recovered to %.2f in %.3f Seconds\n", event.Spec, event.Node, event.Offset, event.PreEventRate, event.RecoveryTime))
 		}
 	}
+	fmt.Fprint(w, "#################################################\n")
+	fmt.Fprint(w, "\r\n")
+	w.Flush()
 
-	if closed {
-		return
+	res := testResult{
+		StartTime:             start_time.Unix(),
+		Duration:              duration.Seconds(),
+		MessageRate:           messageRate,
+		TotalMessages:         totalMessages,
+		TotalSubscriptions:    total_subscriptions,
+		ChannelMin:            channel_minimum,
+		ChannelMax:            channel_maximum,
+		SubscribersPerChannel: subscribers_per_channel,
+		PublishRate:           publish_rate,
+		DataSize:              data_size,
+		MessagesPerChannel:    int64(messages_per_channel_subscriber),
+		MessageRateTs:         messageRateTs,
+		Warmup:                warmup.Seconds(),
+		WarmupRateTs:          warmupRateTs,
+		LatencyMean:           latencyMean,
+		LatencyP50:            latencyP50,
+		LatencyP99:            latencyP99,
+		LatencyMax:            latencyMax,
+		BacklogTs:             backlogTs,
+		ConsumerIdleTime:      consumerIdleTime,
+		DeliveryStats:         subscribe.DeliveryStats(),
+		Calibration:           calibration,
+		RedisClient:           result_redis_client,
+		RoleStats:             roleStats,
+		Addresses:             subscribe.Addresses(),
+		SubscribeLatencyP50:   subscribeLatencyP50,
+		SubscribeLatencyP99:   subscribeLatencyP99,
+		ACL:                   acl,
+		Sentinel:              sentinel,
+		SlotMigrations:        slotMigrations,
+		Chaos:                 chaosEvents,
 	}
 
 	// tell the goroutine to stop
 	close(stopChan)
 	// and wait for them both to reply back
 	wg.Wait()
+	subscribe.DeleteACLUsers()
+	subscribe.StopFaultProxies()
+	subscribe.DisableTopologyRefresh()
+	return res, completed
+}
+
+// writeJSON writes the results to the --json-out-file, if set.
+func writeJSON(json_out_file string, res interface{}) {
+	if strings.Compare(json_out_file, "") == 0 {
+		return
+	}
+	file, err := json.MarshalIndent(res, "", " ")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(json_out_file, file, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
-func updateCLI(tick *time.Ticker, c chan os.Signal, message_limit int64, w *tabwriter.Writer, test_time int) (bool, time.Time, time.Duration, uint64, []float64) {
+func updateCLI(tick *time.Ticker, c chan os.Signal, message_limit int64, w *tabwriter.Writer, test_time int, warmup time.Duration, control *runControl) (bool, time.Time, time.Duration, uint64, []float64, []int64, []float64) {
 
 	start := time.Now()
 	prevTime := time.Now()
 	prevMessageCount := uint64(0)
+	// the messages received during the warm-up are not reported
+	warming := warmup > 0
+	warmupMessageCount := uint64(0)
 	messageRateTs := []float64{}
+	backlogTs := []int64{}
+	warmupRateTs := []float64{}
+	var done <-chan struct{}
+	var deadline <-chan time.Time
+	if control != nil {
+		done = control.done
+		if control.deadline > 0 {
+			deadline = time.After(control.deadline)
+		}
+	}
+	started := false
 
 	w.Init(os.Stdout, 25, 0, 1, ' ', tabwriter.AlignRight)
-	fmt.Fprint(w, fmt.Sprintf("Test Time\tTotal Messages\t Message Rate \t"))
+	fmt.Fprint(w, fmt.Sprintf("Test Time\tTotal Messages\t Message Rate \t Backlog \t"))
 	fmt.Fprint(w, "\n")
 	w.Flush()
 	for {
@@ -193,29 +630,64 @@ func updateCLI(tick *time.Ticker, c chan os.Signal, message_limit int64, w *tabw
 				if prevMessageCount == 0 && subscribe.TotalMessages != 0 {
 					start = time.Now()
 				}
-				if subscribe.TotalMessages != 0 {
+				backlog := atomic.LoadInt64(&subscribe.Backlog)
+				elapsed := time.Since(start)
+				marker := ""
+				if warming && subscribe.TotalMessages != 0 {
+					warmupRateTs = append(warmupRateTs, messageRate)
+					marker = "warm-up"
+					// the ticks come slightly early or late, allow half of one
+					if elapsed+took/2 >= warmup {
+						// measure from this tick on
+						warming = false
+						start, warmupMessageCount = time.Now(), subscribe.TotalMessages
+						subscribe.ResetMessageMetrics()
+					}
+				} else if subscribe.TotalMessages != 0 {
 					messageRateTs = append(messageRateTs, messageRate)
+					backlogTs = append(backlogTs, backlog)
 				}
 				prevMessageCount = subscribe.TotalMessages
 				prevTime = now
 
-				fmt.Fprint(w, fmt.Sprintf("%.0f\t%d\t%.2f\t", time.Since(start).Seconds(), subscribe.TotalMessages, messageRate))
+				fmt.Fprint(w, fmt.Sprintf("%.0f\t%d\t%.2f\t%d\t%s\t", elapsed.Seconds(), subscribe.TotalMessages, messageRate, backlog, marker))
 				fmt.Fprint(w, "\r\n")
 				w.Flush()
-				if message_limit > 0 && subscribe.TotalMessages >= uint64(message_limit) {
-					return true, start, time.Since(start), subscribe.TotalMessages, messageRateTs
+				if warming {
+					break
+				}
+				if control != nil && !started && subscribe.TotalMessages != 0 {
+					started = true
+					control.started <- start
+				}
+				if message_limit > 0 && subscribe.TotalMessages-warmupMessageCount >= uint64(message_limit) {
+					return true, start, time.Since(start), subscribe.TotalMessages - warmupMessageCount, messageRateTs, backlogTs, warmupRateTs
 				}
 				if test_time > 0 && time.Since(start) >= time.Duration(test_time*1000*1000*1000) && subscribe.TotalMessages != 0 {
-					return true, start, time.Since(start), subscribe.TotalMessages, messageRateTs
+					return true, start, time.Since(start), subscribe.TotalMessages - warmupMessageCount, messageRateTs, backlogTs, warmupRateTs
 				}
 
 				break
 			}
 
+		case <-done:
+			return true, start, time.Since(start), subscribe.TotalMessages - warmupMessageCount, messageRateTs, backlogTs, warmupRateTs
+
+		case <-deadline:
+			return true, start, time.Since(start), subscribe.TotalMessages - warmupMessageCount, messageRateTs, backlogTs, warmupRateTs
+
 		case <-c:
 			fmt.Println("received Ctrl-c - shutting down")
-			return true, start, time.Since(start), subscribe.TotalMessages, messageRateTs
+			return false, start, time.Since(start), subscribe.TotalMessages - warmupMessageCount, messageRateTs, backlogTs, warmupRateTs
+		}
+	}
+}
+
+func contains(choices []string, value string) bool {
+	for _, choice := range choices {
+		if choice == value {
+			return true
 		}
 	}
-	return false, start, time.Since(start), subscribe.TotalMessages, messageRateTs
+	return false
 }
@@ -0,0 +1,200 @@
+/*
+Copyright © 2022 codeperfio <filipecosta.90@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// sweepCmd represents the sweep command
+var sweepCmd = &cobra.Command{
+	Use:   "sweep",
+	Short: "Run the subscribe workload over a matrix of parameters",
+	Long: `Run the subscribe workload described by the flags once per combination of the --param values ( their cartesian
+product ), --repetitions times each with --cool-down between runs, and write the metrics of every run to a single
+table ( --csv-out-file and --json-out-file ).`,
+	Run: sweepLogic,
+}
+
+func init() {
+	rootCmd.AddCommand(sweepCmd)
+	sweepCmd.Flags().StringArray("param", []string{}, "\"<flag name>=<value>,<value>...\" values of a subscribe flag ( e.g. channel-maximum=10,100,1000 ). Can be repeated, one per swept flag.")
+	sweepCmd.Flags().Int("repetitions", 1, "number of runs of each combination.")
+	sweepCmd.Flags().Duration("cool-down", 0, "pause between two runs.")
+	sweepCmd.Flags().String("csv-out-file", "", "Name of csv output file, if not set, will not print to csv.")
+}
+
+// sweepParam is a flag and the values it takes during the sweep.
+type sweepParam struct {
+	Name   string
+	Values []string
+}
+
+// sweepRun is a run of the sweep, with the parameter values it used.
+type sweepRun struct {
+	Parameters map[string]string `json:"Parameters"`
+	Repetition int               `json:"Repetition"`
+	testResult
+}
+
+type sweepResult struct {
+	Parameters []string   `json:"Parameters"`
+	Runs       []sweepRun `json:"Runs"`
+}
+
+// sweepColumns are the testResult metrics written to the csv output.
+var sweepColumns = []string{"StartTime", "Duration", "MessageRate", "TotalMessages", "TotalSubscriptions", "ChannelMin", "ChannelMax", "SubscribersPerChannel", "PublishRate", "DataSize", "LatencyMean", "LatencyP50", "LatencyP99", "LatencyMax", "SubscribeLatencyP50", "SubscribeLatencyP99", "ConsumerIdleTime", "RedisClient"}
+
+func sweepMetrics(res testResult) []string {
+	float := func(value float64) string { return strconv.FormatFloat(value, 'f', -1, 64) }
+	return []string{strconv.FormatInt(res.StartTime, 10), float(res.Duration), float(res.MessageRate), strconv.FormatUint(res.TotalMessages, 10), strconv.Itoa(res.TotalSubscriptions), strconv.Itoa(res.ChannelMin), strconv.Itoa(res.ChannelMax), strconv.Itoa(res.SubscribersPerChannel), strconv.Itoa(res.PublishRate), strconv.Itoa(res.DataSize), float(res.LatencyMean), float(res.LatencyP50), float(res.LatencyP99), float(res.LatencyMax), float(res.SubscribeLatencyP50), float(res.SubscribeLatencyP99), float(res.ConsumerIdleTime), res.RedisClient}
+}
+
+// parseSweepParams parses the --param values, checking that each one names a flag.
+func parseSweepParams(cmd *cobra.Command, specs []string) (params []sweepParam, err error) {
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("invalid --param %q, expected \"<flag name>=<value>,<value>...\"", spec)
+		}
+		if cmd.Flags().Lookup(kv[0]) == nil || kv[0] == "workload" {
+			return nil, fmt.Errorf("invalid --param %q: unsupported flag %s", spec, kv[0])
+		}
+		params = append(params, sweepParam{Name: kv[0], Values: strings.Split(kv[1], ",")})
+	}
+	return
+}
+
+// setSweepValues sets the flags to the values of a sweep point, given by the position of the value of each parameter.
+// The repeatable flags are replaced rather than appended to, so that a point does not keep the values of the previous ones.
+func setSweepValues(cmd *cobra.Command, params []sweepParam, positions []int) (values map[string]string, description []string, err error) {
+	values = map[string]string{}
+	for pos, param := range params {
+		value := param.Values[positions[pos]]
+		if err := setFlag(cmd, param.Name, value); err != nil {
+			return nil, nil, fmt.Errorf("invalid --param %s value %s: %v", param.Name, value, err)
+		}
+		values[param.Name] = value
+		description = append(description, param.Name+"="+value)
+	}
+	return
+}
+
+func sweepLogic(cmd *cobra.Command, args []string) {
+	json_out_file, _ := cmd.Flags().GetString("json-out-file")
+	csv_out_file, _ := cmd.Flags().GetString("csv-out-file")
+	param_specs, _ := cmd.Flags().GetStringArray("param")
+	repetitions, _ := cmd.Flags().GetInt("repetitions")
+	cool_down, _ := cmd.Flags().GetDuration("cool-down")
+	params, err := parseSweepParams(cmd, param_specs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(params) == 0 {
+		log.Fatal(fmt.Errorf("at least one --param is required"))
+	}
+	if repetitions < 1 {
+		log.Fatal(fmt.Errorf("--repetitions must be at least 1"))
+	}
+	total := repetitions
+	for _, param := range params {
+		total *= len(param.Values)
+	}
+
+	result := sweepResult{}
+	for _, param := range params {
+		result.Parameters = append(result.Parameters, param.Name)
+	}
+	// positions holds the index of the current value of each parameter, the last one changing first
+	positions := make([]int, len(params))
+	for run := 0; run < total; run++ {
+		if run > 0 && cool_down > 0 {
+			log.Printf("Cooling down during %s", cool_down)
+			time.Sleep(cool_down)
+		}
+		values, description, err := setSweepValues(cmd, params, positions)
+		if err != nil {
+			log.Fatal(err)
+		}
+		test_time, _ := cmd.Flags().GetInt("test-time")
+		messages, _ := cmd.Flags().GetInt("messages")
+		if test_time == 0 && messages == 0 {
+			log.Fatal(fmt.Errorf("the sweep runs require --test-time or --messages"))
+		}
+		repetition := run%repetitions + 1
+		log.Printf("Sweep run %d/%d: %s, repetition %d", run+1, total, strings.Join(description, " "), repetition)
+		res, completed := runSubscribe(cmd, nil)
+		result.Runs = append(result.Runs, sweepRun{Parameters: values, Repetition: repetition, testResult: res})
+		if !completed {
+			log.Printf("Sweep interrupted during run %d/%d", run+1, total)
+			break
+		}
+		// the calibration baseline does not change between runs
+		cmd.Flags().Set("calibration-time", "0")
+		if repetition == repetitions {
+			for pos := len(positions) - 1; pos >= 0; pos-- {
+				if positions[pos]++; positions[pos] < len(params[pos].Values) {
+					break
+				}
+				positions[pos] = 0
+			}
+		}
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 25, 0, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, strings.Join(result.Parameters, "\t")+"\tRepetition\tMessage Rate\tLatency p50 (ms)\tLatency p99 (ms)\t\n")
+	for _, run := range result.Runs {
+		for _, name := range result.Parameters {
+			fmt.Fprint(w, run.Parameters[name]+"\t")
+		}
+		fmt.Fprint(w, fmt.Sprintf("%d\t%.2f\t%.3f\t%.3f\t\n", run.Repetition, run.MessageRate, run.LatencyP50, run.LatencyP99))
+	}
+	w.Flush()
+
+	if strings.Compare(csv_out_file, "") != 0 {
+		file, err := os.Create(csv_out_file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		writer := csv.NewWriter(file)
+		writer.Write(append(append(append([]string{}, result.Parameters...), "Repetition"), sweepColumns...))
+		for _, run := range result.Runs {
+			row := []string{}
+			for _, name := range result.Parameters {
+				row = append(row, run.Parameters[name])
+			}
+			writer.Write(append(append(row, strconv.Itoa(run.Repetition)), sweepMetrics(run.testResult)...))
+		}
+		writer.Flush()
+		if err = writer.Error(); err == nil {
+			err = file.Close()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	writeJSON(json_out_file, result)
+}
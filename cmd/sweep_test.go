@@ -0,0 +1,52 @@
+/*
+Copyright © 2022 codeperfio <filipecosta.90@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestSetSweepValues(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.Flags().StringArray("chaos", []string{}, "")
+	cmd.Flags().Int("channel-maximum", 100, "")
+	params := []sweepParam{
+		{Name: "chaos", Values: []string{"10s client-kill", "20s restart"}},
+		{Name: "channel-maximum", Values: []string{"10", "20"}},
+	}
+	for pos := range params[0].Values {
+		values, description, err := setSweepValues(cmd, params, []int{pos, pos})
+		if err != nil {
+			t.Fatal(err)
+		}
+		chaos, _ := cmd.Flags().GetStringArray("chaos")
+		if want := []string{params[0].Values[pos]}; !reflect.DeepEqual(chaos, want) {
+			t.Errorf("point %d: chaos = %v, want %v", pos, chaos, want)
+		}
+		if values["channel-maximum"] != params[1].Values[pos] {
+			t.Errorf("point %d: values = %v", pos, values)
+		}
+		if want := []string{"chaos=" + params[0].Values[pos], "channel-maximum=" + params[1].Values[pos]}; !reflect.DeepEqual(description, want) {
+			t.Errorf("point %d: description = %v, want %v", pos, description, want)
+		}
+	}
+	if _, _, err := setSweepValues(cmd, []sweepParam{{Name: "channel-maximum", Values: []string{"many"}}}, []int{0}); err == nil {
+		t.Error("expected an error for an invalid value")
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright © 2022 codeperfio <filipecosta.90@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/codeperfio/pubsub-bench/cmd/subscribe"
+	"github.com/spf13/cobra"
+)
+
+// topologyCmd represents the topology command
+var topologyCmd = &cobra.Command{
+	Use:   "topology",
+	Short: "Print the discovered cluster topology",
+	Long: `Discover the cluster topology from the --host/--port seed nodes, using CLUSTER SHARDS
+(falling back to CLUSTER SLOTS on redis < 7.0), and print its primaries, replicas and slot ranges.`,
+	Run: topologyLogic,
+}
+
+func init() {
+	rootCmd.AddCommand(topologyCmd)
+	topologyCmd.Flags().String("format", "table", "(table,json) output format.")
+}
+
+func topologyLogic(cmd *cobra.Command, args []string) {
+	host, _ := cmd.Flags().GetString("host")
+	port, _ := cmd.Flags().GetString("port")
+	format, _ := cmd.Flags().GetString("format")
+	debugLevel, _ := cmd.Flags().GetInt("debug-level")
+	applyRedisConnectionOptions()
+	applyRedisEndpoints(host, port, debugLevel)
+	host, _ = applySentinel(host)
+
+	topology, err := subscribe.DiscoverTopologyFromArgs(host, port)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch format {
+	case "json":
+		out, err := json.MarshalIndent(topology, "", " ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Shard\tRole\tAddress\tAnnounced\tId\tHealth\tSlots\t\n")
+		for pos, shard := range topology.Shards {
+			slots := []string{}
+			for _, slotRange := range shard.Slots {
+				slots = append(slots, fmt.Sprintf("%d-%d", slotRange[0], slotRange[1]))
+			}
+			for _, node := range append([]subscribe.ClusterNode{shard.Primary}, shard.Replicas...) {
+				announced := node.AnnouncedAddr
+				if announced == "" {
+					announced = node.Addr
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t\n", pos, node.Role, node.Addr, announced, node.Id, node.Health, strings.Join(slots, ","))
+			}
+		}
+		w.Flush()
+		fmt.Printf("Discovered %d shards using %s\n", len(topology.Shards), topology.Source)
+	default:
+		log.Fatal(fmt.Errorf("unsupported --format %s ( choices table,json )", format))
+	}
+}
@@ -0,0 +1,449 @@
+/*
+Copyright © 2022 codeperfio <filipecosta.90@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/codeperfio/pubsub-bench/cmd/subscribe"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var workloadPhaseRamp string = "ramp"
+var workloadPhaseStep string = "step"
+var workloadPhaseSpike string = "spike"
+var workloadPhaseChoices []string = []string{workloadPhaseRamp, workloadPhaseStep, workloadPhaseSpike}
+
+// workloadPhaseBurst is the default time a spike phase holds its publish rate.
+const workloadPhaseBurst = time.Second
+
+func init() {
+	rootCmd.PersistentFlags().String("workload", "", "YAML or JSON workload file. Its top level keys set the flags of the same name not given on the command line, and its phases list the timed stages of a single run, each one changing the publish-rate, data-size and subscribers-per-channel live. The metrics are reported per phase.")
+	// allow the workload to be kept in the config file
+	if err := viper.BindPFlag("workload", rootCmd.PersistentFlags().Lookup("workload")); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// workloadPhase is a timed stage of a workload run.
+type workloadPhase struct {
+	Name     string
+	Type     string
+	Duration time.Duration
+	// PublishRate is the rate the phase starts with, or the burst rate of a spike phase. 0 keeps the previous rate
+	PublishRate int
+	// PublishRateEnd is the publish rate a ramp phase reaches at its end
+	PublishRateEnd int
+	// SubscribersPerChannel is the number of subscribers per channel during the phase. 0 keeps the previous number
+	SubscribersPerChannel int
+	// DataSize is the payload size of the messages published during the phase. 0 keeps the previous size
+	DataSize int
+	// Burst is the time a spike phase holds its publish rate before returning to BaseRate
+	Burst    time.Duration
+	BaseRate int
+}
+
+// rateAt returns the publish rate elapsed time into the phase.
+func (phase workloadPhase) rateAt(elapsed time.Duration) float64 {
+	switch phase.Type {
+	case workloadPhaseRamp:
+		progress := elapsed.Seconds() / phase.Duration.Seconds()
+		if progress > 1 {
+			progress = 1
+		}
+		return float64(phase.PublishRate) + float64(phase.PublishRateEnd-phase.PublishRate)*progress
+	case workloadPhaseSpike:
+		if elapsed >= phase.Burst {
+			return float64(phase.BaseRate)
+		}
+	}
+	return float64(phase.PublishRate)
+}
+
+// endRate is the publish rate the phase ends with, and the next one starts from.
+func (phase workloadPhase) endRate() int {
+	switch phase.Type {
+	case workloadPhaseRamp:
+		return phase.PublishRateEnd
+	case workloadPhaseSpike:
+		return phase.BaseRate
+	}
+	return phase.PublishRate
+}
+
+// phaseResult is the outcome of a workload phase.
+type phaseResult struct {
+	Name string `json:"Name"`
+	Type string `json:"Type"`
+	// Offset is the time, in seconds, the phase started at since the first message
+	Offset                float64 `json:"Offset"`
+	Duration              float64 `json:"Duration"`
+	PublishRate           int     `json:"PublishRate"`
+	PublishRateEnd        int     `json:"PublishRateEnd,omitempty"`
+	Burst                 float64 `json:"Burst,omitempty"`
+	SubscribersPerChannel int     `json:"SubscribersPerChannel"`
+	DataSize              int     `json:"DataSize"`
+	TotalMessages         uint64  `json:"TotalMessages"`
+	MessageRate           float64 `json:"MessageRate"`
+	LatencyMean           float64 `json:"LatencyMean"`
+	LatencyP50            float64 `json:"LatencyP50"`
+	LatencyP99            float64 `json:"LatencyP99"`
+	LatencyMax            float64 `json:"LatencyMax"`
+}
+
+type workloadResult struct {
+	Workload string        `json:"Workload"`
+	Phases   []phaseResult `json:"Phases"`
+	// Run is the whole run, all phases included
+	Run testResult `json:"Run"`
+}
+
+// loadWorkload reads a workload file, returning its flag settings and its phases.
+func loadWorkload(cmd *cobra.Command, workload_file string) (settings map[string]interface{}, phases []workloadPhase, err error) {
+	spec := viper.New()
+	spec.SetConfigFile(workload_file)
+	if err = spec.ReadInConfig(); err != nil {
+		return nil, nil, fmt.Errorf("unable to read the workload %s: %v", workload_file, err)
+	}
+	settings = spec.AllSettings()
+	delete(settings, "phases")
+	for name := range settings {
+		if cmd.Flags().Lookup(name) == nil || name == "workload" {
+			return nil, nil, fmt.Errorf("workload %s: unsupported setting %s", workload_file, name)
+		}
+	}
+	entries, _ := spec.Get("phases").([]interface{})
+	if len(entries) == 0 {
+		return nil, nil, fmt.Errorf("workload %s: no phases defined", workload_file)
+	}
+	for pos, entry := range entries {
+		phase, err := parseWorkloadPhase(cmd, pos, entry)
+		if err != nil {
+			return nil, nil, fmt.Errorf("workload %s: %v", workload_file, err)
+		}
+		phases = append(phases, phase)
+	}
+	return
+}
+
+func parseWorkloadPhase(cmd *cobra.Command, pos int, entry interface{}) (phase workloadPhase, err error) {
+	values, err := cast.ToStringMapE(entry)
+	if err != nil {
+		return phase, fmt.Errorf("phase %d is not a map of settings", pos+1)
+	}
+	phase = workloadPhase{Name: fmt.Sprintf("phase-%d", pos+1), Type: workloadPhaseStep}
+	burst := ""
+	for name, value := range values {
+		switch name {
+		case "name":
+			phase.Name = cast.ToString(value)
+		case "type":
+			phase.Type = cast.ToString(value)
+		case "duration", "burst":
+			var duration time.Duration
+			// a plain number is a number of seconds, as --test-time
+			if seconds, failed := cast.ToFloat64E(value); failed == nil {
+				duration = time.Duration(seconds * float64(time.Second))
+			} else {
+				duration, err = time.ParseDuration(cast.ToString(value))
+			}
+			if name == "burst" {
+				phase.Burst, burst = duration, cast.ToString(value)
+			} else {
+				phase.Duration = duration
+			}
+		case "publish-rate":
+			if phase.PublishRate, err = cast.ToIntE(value); err == nil && phase.PublishRate <= 0 {
+				err = fmt.Errorf("publish-rate must be positive")
+			}
+		case "publish-rate-end":
+			phase.PublishRateEnd, err = cast.ToIntE(value)
+		case "subscribers-per-channel":
+			if phase.SubscribersPerChannel, err = cast.ToIntE(value); err == nil && phase.SubscribersPerChannel <= 0 {
+				err = fmt.Errorf("subscribers-per-channel must be positive")
+			}
+		case "data-size":
+			if phase.DataSize, err = cast.ToIntE(value); err == nil && phase.DataSize <= 0 {
+				err = fmt.Errorf("data-size must be positive")
+			}
+		case "test-time", "messages":
+			err = fmt.Errorf("%s is set by the phase duration", name)
+		default:
+			if cmd.Flags().Lookup(name) == nil || name == "workload" {
+				err = fmt.Errorf("unsupported setting %s", name)
+			} else {
+				err = fmt.Errorf("%s can not change during the run, set it at the top level of the workload", name)
+			}
+		}
+		if err != nil {
+			return phase, fmt.Errorf("phase %s: %v", phase.Name, err)
+		}
+	}
+	if !contains(workloadPhaseChoices, phase.Type) {
+		return phase, fmt.Errorf("phase %s: unsupported type %s ( choices %s )", phase.Name, phase.Type, strings.Join(workloadPhaseChoices, ","))
+	}
+	if phase.Duration <= 0 {
+		return phase, fmt.Errorf("phase %s: a duration is required", phase.Name)
+	}
+	if (phase.Type == workloadPhaseRamp) != (phase.PublishRateEnd > 0) {
+		return phase, fmt.Errorf("phase %s: publish-rate-end is required by, and only supported by, the %s phases", phase.Name, workloadPhaseRamp)
+	}
+	if phase.Type != workloadPhaseSpike {
+		if burst != "" {
+			return phase, fmt.Errorf("phase %s: burst is only supported by the %s phases", phase.Name, workloadPhaseSpike)
+		}
+		return phase, nil
+	}
+	if phase.PublishRate == 0 {
+		return phase, fmt.Errorf("phase %s: a %s phase requires the publish-rate of its burst", phase.Name, workloadPhaseSpike)
+	}
+	if burst == "" {
+		phase.Burst = workloadPhaseBurst
+	}
+	if phase.Burst <= 0 || phase.Burst >= phase.Duration {
+		return phase, fmt.Errorf("phase %s: the burst must be shorter than the phase duration, to measure the recovery", phase.Name)
+	}
+	return phase, nil
+}
+
+// checkWorkloadPhases rejects the phase settings the system can not change during the run.
+func checkWorkloadPhases(system string, phases []workloadPhase) error {
+	for _, phase := range phases {
+		// the keyspace subscribers are per node, not per channel
+		if system == redisKeyspaceNotifications && phase.SubscribersPerChannel != 0 {
+			return fmt.Errorf("phase %s: subscribers-per-channel can not change during the run of the %s system", phase.Name, system)
+		}
+		if phase.DataSize == 0 {
+			continue
+		}
+		// the keyspace writers do not send any payload
+		if system == redisKeyspaceNotifications {
+			return fmt.Errorf("phase %s: data-size is not supported by the %s system", phase.Name, system)
+		}
+		if system == postgresNotify && phase.DataSize > subscribe.PostgresMaxPayload {
+			return fmt.Errorf("phase %s: data-size %d exceeds the maximum NOTIFY payload size of %d bytes", phase.Name, phase.DataSize, subscribe.PostgresMaxPayload)
+		}
+	}
+	return nil
+}
+
+// resolveWorkloadPhases fills in the publish rate, subscribers per channel and data size each phase inherits, starting
+// from the --publish-rate, --subscribers-per-channel and --data-size values.
+func resolveWorkloadPhases(phases []workloadPhase, publish_rate int, subscribers_per_channel int, data_size int) ([]workloadPhase, error) {
+	resolved := []workloadPhase{}
+	for _, phase := range phases {
+		if phase.SubscribersPerChannel == 0 {
+			phase.SubscribersPerChannel = subscribers_per_channel
+		}
+		if phase.DataSize == 0 {
+			phase.DataSize = data_size
+		}
+		if phase.Type == workloadPhaseSpike {
+			phase.BaseRate = publish_rate
+		} else if phase.PublishRate == 0 {
+			phase.PublishRate = publish_rate
+		}
+		if phase.PublishRate <= 0 || (phase.Type == workloadPhaseSpike && phase.BaseRate <= 0) {
+			return nil, fmt.Errorf("phase %s: a publish-rate is required, in the phase or before it", phase.Name)
+		}
+		publish_rate, subscribers_per_channel, data_size = phase.endRate(), phase.SubscribersPerChannel, phase.DataSize
+		resolved = append(resolved, phase)
+	}
+	return resolved, nil
+}
+
+// setFlag sets a flag from a config value, replacing the previous values of the repeatable flags.
+func setFlag(cmd *cobra.Command, name string, value interface{}) error {
+	flag := cmd.Flags().Lookup(name)
+	if flag == nil {
+		return fmt.Errorf("unknown flag %s", name)
+	}
+	values := []string{}
+	if list, ok := value.([]interface{}); ok {
+		for _, item := range list {
+			values = append(values, flagString(item))
+		}
+	} else {
+		values = append(values, flagString(value))
+	}
+	if slice, ok := flag.Value.(pflag.SliceValue); ok {
+		if err := slice.Replace(values); err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		flag.Changed = true
+		return nil
+	}
+	if err := cmd.Flags().Set(name, strings.Join(values, ",")); err != nil {
+		return fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return nil
+}
+
+// flagString formats a config value as a flag value, writing the JSON numbers without exponent.
+func flagString(value interface{}) string {
+	if number, ok := value.(float64); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// runWorkload executes the phases of a workload file as a single run, changing the publish rate, the data size and the
+// number of subscribers live at each phase, and reports the metrics of every phase.
+func runWorkload(cmd *cobra.Command, workload_file string) {
+	settings, phases, err := loadWorkload(cmd, workload_file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the command line flags take precedence over the workload settings
+	for name, value := range settings {
+		if cmd.Flags().Changed(name) {
+			continue
+		}
+		if err := setFlag(cmd, name, value); err != nil {
+			log.Fatal(fmt.Errorf("workload %s: %v", workload_file, err))
+		}
+	}
+	system, _ := cmd.Flags().GetString("system")
+	publish_rate, _ := cmd.Flags().GetInt("publish-rate")
+	subscribers_per_channel, _ := cmd.Flags().GetInt("subscribers-per-channel")
+	data_size, _ := cmd.Flags().GetInt("data-size")
+	if err = checkWorkloadPhases(system, phases); err != nil {
+		log.Fatal(fmt.Errorf("workload %s: %v", workload_file, err))
+	}
+	if phases, err = resolveWorkloadPhases(phases, publish_rate, subscribers_per_channel, data_size); err != nil {
+		log.Fatal(fmt.Errorf("workload %s: %v", workload_file, err))
+	}
+	// the phases end the run
+	cmd.Flags().Set("test-time", "0")
+	cmd.Flags().Set("messages", "0")
+	// the system starts with the settings of the first phase, and then follows the live ones
+	cmd.Flags().Set("publish-rate", strconv.Itoa(phases[0].PublishRate))
+	cmd.Flags().Set("subscribers-per-channel", strconv.Itoa(phases[0].SubscribersPerChannel))
+	cmd.Flags().Set("data-size", strconv.Itoa(phases[0].DataSize))
+	subscribe.SetLivePublishRate(phases[0].rateAt(0))
+	subscribe.SetLiveSubscribersPerChannel(phases[0].SubscribersPerChannel)
+	subscribe.SetLiveDataSize(phases[0].DataSize)
+	defer subscribe.SetLivePublishRate(0)
+	defer subscribe.SetLiveSubscribersPerChannel(0)
+	defer subscribe.SetLiveDataSize(0)
+
+	control := newRunControl()
+	finished := make(chan struct{})
+	phaseResults := make(chan []phaseResult, 1)
+	go func() {
+		phaseResults <- runWorkloadPhases(phases, control, finished)
+	}()
+	res, completed := runSubscribe(cmd, control)
+	close(finished)
+	result := workloadResult{Workload: workload_file, Phases: <-phaseResults, Run: res}
+	if !completed && len(result.Phases) > 0 {
+		log.Printf("Workload interrupted during phase %s", result.Phases[len(result.Phases)-1].Name)
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 25, 0, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, "Phase\tType\tDuration\tPublish Rate\tSubscribers per Channel\tData Size\tMessage Rate\tLatency p50 (ms)\tLatency p99 (ms)\t\n")
+	for _, phase := range result.Phases {
+		publishRate := strconv.Itoa(phase.PublishRate)
+		switch phase.Type {
+		case workloadPhaseRamp:
+			publishRate = fmt.Sprintf("%d-%d", phase.PublishRate, phase.PublishRateEnd)
+		case workloadPhaseSpike:
+			publishRate = fmt.Sprintf("%d for %.3fs", phase.PublishRate, phase.Burst)
+		}
+		fmt.Fprint(w, fmt.Sprintf("%s\t%s\t%.3f\t%s\t%d\t%d\t%.2f\t%.3f\t%.3f\t\n", phase.Name, phase.Type, phase.Duration, publishRate, phase.SubscribersPerChannel, phase.DataSize, phase.MessageRate, phase.LatencyP50, phase.LatencyP99))
+	}
+	w.Flush()
+
+	json_out_file, _ := cmd.Flags().GetString("json-out-file")
+	writeJSON(json_out_file, result)
+}
+
+// runWorkloadPhases drives the live publish rate, data size and number of subscribers through the phases once the run measurements
+// started, measuring each phase, and ends the run after the last one. It stops early when finished is closed.
+func runWorkloadPhases(phases []workloadPhase, control *runControl, finished chan struct{}) (results []phaseResult) {
+	var start time.Time
+	select {
+	case start = <-control.started:
+	case <-finished:
+		return
+	}
+	// the latencies of the warm-up are not part of the first phase
+	subscribe.TakeLatencyWindow()
+	phaseStart := start
+	for pos, phase := range phases {
+		log.Printf("Workload phase %d/%d %s ( %s ) during %s", pos+1, len(phases), phase.Name, phase.Type, phase.Duration)
+		phaseEnd := phaseStart.Add(phase.Duration)
+		messages := atomic.LoadUint64(&subscribe.TotalMessages)
+		subscribe.SetLiveSubscribersPerChannel(phase.SubscribersPerChannel)
+		subscribe.SetLiveDataSize(phase.DataSize)
+		interrupted := false
+		now := time.Now()
+		for now.Before(phaseEnd) && !interrupted {
+			elapsed := now.Sub(phaseStart)
+			subscribe.SetLivePublishRate(phase.rateAt(elapsed))
+			wait := phaseEnd.Sub(now)
+			if phase.Type == workloadPhaseRamp && wait > 100*time.Millisecond {
+				wait = 100 * time.Millisecond
+			}
+			if phase.Type == workloadPhaseSpike && elapsed < phase.Burst {
+				wait = phase.Burst - elapsed
+			}
+			select {
+			case <-time.After(wait):
+			case <-finished:
+				interrupted = true
+			}
+			now = time.Now()
+		}
+		if now.After(phaseEnd) && !interrupted {
+			now = phaseEnd
+		}
+		result := phaseResult{
+			Name:                  phase.Name,
+			Type:                  phase.Type,
+			Offset:                phaseStart.Sub(start).Seconds(),
+			Duration:              now.Sub(phaseStart).Seconds(),
+			PublishRate:           phase.PublishRate,
+			PublishRateEnd:        phase.PublishRateEnd,
+			Burst:                 phase.Burst.Seconds(),
+			SubscribersPerChannel: phase.SubscribersPerChannel,
+			DataSize:              phase.DataSize,
+			TotalMessages:         atomic.LoadUint64(&subscribe.TotalMessages) - messages,
+		}
+		if result.Duration > 0 {
+			result.MessageRate = float64(result.TotalMessages) / result.Duration
+		}
+		result.LatencyMean, result.LatencyP50, result.LatencyP99, result.LatencyMax = subscribe.TakeLatencyWindow()
+		results = append(results, result)
+		if interrupted {
+			return
+		}
+		phaseStart = phaseEnd
+	}
+	close(control.done)
+	return
+}
@@ -0,0 +1,231 @@
+/*
+Copyright © 2022 codeperfio <filipecosta.90@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseWorkloadPhase(t *testing.T) {
+	if err := subscribeCmd.ParseFlags(nil); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		entry   interface{}
+		want    workloadPhase
+		wantErr bool
+	}{
+		{
+			name:  "step defaults",
+			entry: map[string]interface{}{"duration": 30},
+			want:  workloadPhase{Name: "phase-1", Type: workloadPhaseStep, Duration: 30 * time.Second},
+		},
+		{
+			name:  "step",
+			entry: map[string]interface{}{"name": "steady", "duration": "1m30s", "publish-rate": 10, "subscribers-per-channel": 4},
+			want:  workloadPhase{Name: "steady", Type: workloadPhaseStep, Duration: 90 * time.Second, PublishRate: 10, SubscribersPerChannel: 4},
+		},
+		{
+			name:  "data size",
+			entry: map[string]interface{}{"duration": 10, "data-size": 1024},
+			want:  workloadPhase{Name: "phase-1", Type: workloadPhaseStep, Duration: 10 * time.Second, DataSize: 1024},
+		},
+		{
+			name:  "fractional seconds",
+			entry: map[string]interface{}{"duration": 2.5},
+			want:  workloadPhase{Name: "phase-1", Type: workloadPhaseStep, Duration: 2500 * time.Millisecond},
+		},
+		{
+			name:  "ramp",
+			entry: map[string]interface{}{"type": "ramp", "duration": "10s", "publish-rate": 1, "publish-rate-end": 100},
+			want:  workloadPhase{Name: "phase-1", Type: workloadPhaseRamp, Duration: 10 * time.Second, PublishRate: 1, PublishRateEnd: 100},
+		},
+		{
+			name:  "spike with the default burst",
+			entry: map[string]interface{}{"type": "spike", "duration": "10s", "publish-rate": 1000},
+			want:  workloadPhase{Name: "phase-1", Type: workloadPhaseSpike, Duration: 10 * time.Second, PublishRate: 1000, Burst: workloadPhaseBurst},
+		},
+		{
+			name:  "spike",
+			entry: map[string]interface{}{"type": "spike", "duration": "10s", "publish-rate": 1000, "burst": "250ms"},
+			want:  workloadPhase{Name: "phase-1", Type: workloadPhaseSpike, Duration: 10 * time.Second, PublishRate: 1000, Burst: 250 * time.Millisecond},
+		},
+		{name: "not a map", entry: "step", wantErr: true},
+		{name: "missing duration", entry: map[string]interface{}{"publish-rate": 10}, wantErr: true},
+		{name: "invalid duration", entry: map[string]interface{}{"duration": "soon"}, wantErr: true},
+		{name: "unsupported type", entry: map[string]interface{}{"type": "wave", "duration": 1}, wantErr: true},
+		{name: "ramp without end", entry: map[string]interface{}{"type": "ramp", "duration": 1}, wantErr: true},
+		{name: "step with end", entry: map[string]interface{}{"duration": 1, "publish-rate-end": 10}, wantErr: true},
+		{name: "spike without rate", entry: map[string]interface{}{"type": "spike", "duration": 10}, wantErr: true},
+		{name: "spike burst as long as the phase", entry: map[string]interface{}{"type": "spike", "duration": 1, "publish-rate": 10, "burst": 1}, wantErr: true},
+		{name: "burst outside a spike", entry: map[string]interface{}{"duration": 10, "burst": 1}, wantErr: true},
+		{name: "zero publish rate", entry: map[string]interface{}{"duration": 1, "publish-rate": 0}, wantErr: true},
+		{name: "zero subscribers", entry: map[string]interface{}{"duration": 1, "subscribers-per-channel": 0}, wantErr: true},
+		{name: "zero data size", entry: map[string]interface{}{"duration": 1, "data-size": 0}, wantErr: true},
+		{name: "test time", entry: map[string]interface{}{"duration": 1, "test-time": 10}, wantErr: true},
+		{name: "flag fixed for the run", entry: map[string]interface{}{"duration": 1, "channel-maximum": 10}, wantErr: true},
+		{name: "unknown setting", entry: map[string]interface{}{"duration": 1, "colour": "blue"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseWorkloadPhase(subscribeCmd, 0, tt.entry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWorkloadPhase(%v) error = %v, wantErr %v", tt.entry, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseWorkloadPhase(%v) = %+v, want %+v", tt.entry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveWorkloadPhases(t *testing.T) {
+	phases := []workloadPhase{
+		{Name: "ramp", Type: workloadPhaseRamp, PublishRateEnd: 100},
+		{Name: "steady", Type: workloadPhaseStep, SubscribersPerChannel: 3},
+		{Name: "spike", Type: workloadPhaseSpike, PublishRate: 1000, Burst: time.Second, DataSize: 1024},
+		{Name: "after", Type: workloadPhaseStep},
+	}
+	got, err := resolveWorkloadPhases(phases, 10, 1, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []workloadPhase{
+		{Name: "ramp", Type: workloadPhaseRamp, PublishRate: 10, PublishRateEnd: 100, SubscribersPerChannel: 1, DataSize: 128},
+		{Name: "steady", Type: workloadPhaseStep, PublishRate: 100, SubscribersPerChannel: 3, DataSize: 128},
+		{Name: "spike", Type: workloadPhaseSpike, PublishRate: 1000, Burst: time.Second, BaseRate: 100, SubscribersPerChannel: 3, DataSize: 1024},
+		{Name: "after", Type: workloadPhaseStep, PublishRate: 100, SubscribersPerChannel: 3, DataSize: 1024},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveWorkloadPhases() = %+v, want %+v", got, want)
+	}
+	if _, err := resolveWorkloadPhases([]workloadPhase{{Name: "steady", Type: workloadPhaseStep}}, 0, 1, 128); err == nil {
+		t.Errorf("a phase without any publish rate must be rejected")
+	}
+	if _, err := resolveWorkloadPhases([]workloadPhase{{Name: "spike", Type: workloadPhaseSpike, PublishRate: 100}}, 0, 1, 128); err == nil {
+		t.Errorf("a spike without a rate to return to must be rejected")
+	}
+}
+
+func TestCheckWorkloadPhases(t *testing.T) {
+	tests := []struct {
+		name    string
+		system  string
+		phases  []workloadPhase
+		wantErr bool
+	}{
+		{"data size", redisPubSub, []workloadPhase{{Name: "a"}, {Name: "b", DataSize: 4096}}, false},
+		{"keyspace without data size", redisKeyspaceNotifications, []workloadPhase{{Name: "a", PublishRate: 10}}, false},
+		{"keyspace data size", redisKeyspaceNotifications, []workloadPhase{{Name: "a", DataSize: 4096}}, true},
+		{"subscribers", natsPubSub, []workloadPhase{{Name: "a"}, {Name: "b", SubscribersPerChannel: 4}}, false},
+		{"keyspace subscribers", redisKeyspaceNotifications, []workloadPhase{{Name: "a"}, {Name: "b", SubscribersPerChannel: 4}}, true},
+		{"postgres data size", postgresNotify, []workloadPhase{{Name: "a", DataSize: 4096}}, false},
+		{"postgres data size above the notify limit", postgresNotify, []workloadPhase{{Name: "a", DataSize: 8192}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkWorkloadPhases(tt.system, tt.phases); (err != nil) != tt.wantErr {
+				t.Errorf("checkWorkloadPhases() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWorkloadPhaseRateAt(t *testing.T) {
+	ramp := workloadPhase{Type: workloadPhaseRamp, Duration: 10 * time.Second, PublishRate: 10, PublishRateEnd: 110}
+	spike := workloadPhase{Type: workloadPhaseSpike, Duration: 10 * time.Second, PublishRate: 1000, Burst: 2 * time.Second, BaseRate: 50}
+	step := workloadPhase{Type: workloadPhaseStep, Duration: 10 * time.Second, PublishRate: 20}
+	tests := []struct {
+		name    string
+		phase   workloadPhase
+		elapsed time.Duration
+		want    float64
+	}{
+		{"ramp start", ramp, 0, 10},
+		{"ramp middle", ramp, 5 * time.Second, 60},
+		{"ramp end", ramp, 10 * time.Second, 110},
+		{"ramp after its end", ramp, 11 * time.Second, 110},
+		{"spike burst", spike, 0, 1000},
+		{"spike end of burst", spike, 1999 * time.Millisecond, 1000},
+		{"spike return", spike, 2 * time.Second, 50},
+		{"spike recovery", spike, 9 * time.Second, 50},
+		{"step", step, 5 * time.Second, 20},
+	}
+	for _, tt := range tests {
+		if got := tt.phase.rateAt(tt.elapsed); got != tt.want {
+			t.Errorf("%s: rateAt(%s) = %f, want %f", tt.name, tt.elapsed, got, tt.want)
+		}
+	}
+	for _, phase := range []workloadPhase{ramp, spike, step} {
+		if got, want := phase.endRate(), int(phase.rateAt(phase.Duration)); got != want {
+			t.Errorf("%s endRate() = %d, want the final rate %d", phase.Type, got, want)
+		}
+	}
+}
+
+func TestLoadWorkload(t *testing.T) {
+	if err := subscribeCmd.ParseFlags(nil); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	tests := []struct {
+		name         string
+		content      string
+		wantSettings map[string]interface{}
+		wantPhases   []string
+		wantErr      bool
+	}{
+		{
+			name:         "yaml",
+			content:      "system: inmemory\nchannel-maximum: 10\nphases:\n  - name: a\n    duration: 1s\n  - duration: 2s\n",
+			wantSettings: map[string]interface{}{"system": "inmemory", "channel-maximum": 10},
+			wantPhases:   []string{"a", "phase-2"},
+		},
+		{name: "no phases", content: "system: inmemory\n", wantErr: true},
+		{name: "unsupported setting", content: "colour: blue\nphases:\n  - duration: 1s\n", wantErr: true},
+		{name: "invalid phase", content: "phases:\n  - duration: 1s\n    type: wave\n", wantErr: true},
+	}
+	for pos, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, string(rune('a'+pos))+".yaml")
+			if err := os.WriteFile(file, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			settings, phases, err := loadWorkload(subscribeCmd, file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadWorkload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(settings, tt.wantSettings) {
+				t.Errorf("settings = %v, want %v", settings, tt.wantSettings)
+			}
+			names := []string{}
+			for _, phase := range phases {
+				names = append(names, phase.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantPhases) {
+				t.Errorf("phases = %v, want %v", names, tt.wantPhases)
+			}
+		})
+	}
+}